@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/erigontech/diagnostics"
 	api_internal "github.com/erigontech/diagnostics/api/internal"
 	"github.com/erigontech/diagnostics/internal/erigon_node"
 	"github.com/erigontech/diagnostics/internal/sessions"
@@ -27,41 +32,209 @@ type GetVersionResponse struct {
 }
 
 type SessionResponse struct {
-	IsActive   bool                 `json:"is_active"`
-	SessionPin uint64               `json:"session_pin"`
-	Nodes      []*sessions.NodeInfo `json:"nodes"`
+	IsActive   bool       `json:"is_active"`
+	SessionPin uint64     `json:"session_pin"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	// BridgeSecret is given to the nodes attaching to the session, to authenticate over the bridge
+	BridgeSecret string        `json:"bridge_secret,omitempty"`
+	Nodes        []SessionNode `json:"nodes"`
+}
+
+// SessionNode is a node attached to a session, with what is known about its connection
+type SessionNode struct {
+	*sessions.NodeInfo
+	Connected       bool                 `json:"connected"`
+	ProtocolVersion uint64               `json:"protocol_version,omitempty"`
+	Capabilities    []string             `json:"capabilities"` // null when the node does not advertise them
+	Link            sessions.LinkQuality `json:"link"`
 }
 
 type APIHandler struct {
 	chi.Router
 	sessions   sessions.CacheService
+	tokens     *sessions.TokenSigner
 	erigonNode erigon_node.Client
+	logins     *throttle // of failed logins, so PINs can not be guessed
+	issues     *throttle // of issued sessions, so clients can not crowd out the others
 }
 
-func (h *APIHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+// Failed logins are limited by client address and in total, so that however many
+// addresses guesses come from it would take days to find one of the live sessions
+var (
+	loginFailuresPerClient = rateLimit{burst: 5, interval: 10 * time.Second}
+	loginFailures          = rateLimit{burst: 50, interval: 5 * time.Second}
+)
+
+// Sessions issued are limited by client address, and in total generously enough
+// that only clients spread over many addresses are held back by it
+var (
+	sessionIssuesPerClient = rateLimit{burst: 10, interval: time.Minute}
+	sessionIssues          = rateLimit{burst: 100, interval: 100 * time.Millisecond}
+)
+
+// errInvalidPin fails every login which does not find a session, whatever the reason
+var errInvalidPin = diagnostics.AsNotFound(errors.New("invalid session pin"))
+
+type LoginRequest struct {
+	Pin string `json:"pin"`
+}
+
+type LoginResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+type IssueSessionResponse struct {
+	SessionResponse
+	Token        string    `json:"token"`
+	TokenExpires time.Time `json:"token_expires"`
+}
+
+// IssueSession creates a session with a server generated PIN, logging the caller in to it
+func (h *APIHandler) IssueSession(w http.ResponseWriter, r *http.Request) {
+	if wait, ok := h.issues.allow(clientAddress(r)); !ok {
+		tooManyRequests(w, wait)
+		return
+	}
+
+	uiSession, err := h.sessions.IssueUISession()
+
+	if err != nil {
+		api_internal.EncodeError(w, r, err)
+		return
+	}
+
+	login := h.login(w, r, strconv.FormatUint(uiSession.SessionPin, 10))
+
+	response := IssueSessionResponse{
+		SessionResponse: newSessionResponse(uiSession),
+		Token:           login.Token,
+		TokenExpires:    login.Expires,
+	}
+
+	jsonData, err := json.Marshal(response)
+
+	if err != nil {
+		api_internal.EncodeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonData)
+}
+
+// RevokeSession removes the session, its PIN and tokens no longer grant access
+func (h *APIHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, SessionId)
 
-	uiSession, ok := h.sessions.FindUISession(id)
+	if !h.sessions.RevokeUISession(id) {
+		api_internal.EncodeError(w, r, diagnostics.AsNotFound(fmt.Errorf("unknown sessionId: %s", id)))
+		return
+	}
 
-	if !ok {
-		var err error
-		uiSession, err = h.sessions.CreateUISession(id)
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessions.TokenCookie(id),
+		Path:   "/api",
+		MaxAge: -1,
+	})
 
-		if err != nil {
-			api_internal.EncodeError(w, r, err)
-			return
-		}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Login exchanges the PIN of an issued session for a token granting access to it,
+// which is returned in the response and set as the session's cookie
+func (h *APIHandler) Login(w http.ResponseWriter, r *http.Request) {
+	client := clientAddress(r)
+
+	if wait := h.logins.wait(client); wait > 0 {
+		tooManyRequests(w, wait)
+		return
 	}
 
+	var request LoginRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.logins.take(client)
+		api_internal.EncodeError(w, r, diagnostics.AsBadRequestErr(fmt.Errorf("invalid login request: %w", err)))
+		return
+	}
+
+	if _, ok := h.sessions.FindUISession(request.Pin); !ok {
+		h.logins.take(client)
+		api_internal.EncodeError(w, r, errInvalidPin)
+		return
+	}
+
+	jsonData, err := json.Marshal(h.login(w, r, request.Pin))
+
+	if err != nil {
+		api_internal.EncodeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// login issues a token for the session and sets it as the session's cookie
+func (h *APIHandler) login(w http.ResponseWriter, r *http.Request, sessionId string) LoginResponse {
+	token, expires := h.tokens.Issue(sessionId)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessions.TokenCookie(sessionId),
+		Value:    token,
+		Path:     "/api",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return LoginResponse{Token: token, Expires: expires}
+}
+
+func newSessionResponse(uiSession *sessions.UISession) SessionResponse {
 	response := SessionResponse{
 		IsActive:   uiSession.IsActive(),
 		SessionPin: uiSession.SessionPin,
 	}
 
-	for _, node := range uiSession.Nodes {
-		response.Nodes = append(response.Nodes, node.NodeInfo)
+	if !uiSession.Expires.IsZero() {
+		response.Expires = &uiSession.Expires
+	}
+
+	if len(uiSession.BridgeSecret) > 0 {
+		response.BridgeSecret = hex.EncodeToString(uiSession.BridgeSecret)
+	}
+
+	for _, node := range uiSession.NodeSessions() {
+		version, capabilities := node.Protocol()
+
+		response.Nodes = append(response.Nodes, SessionNode{
+			NodeInfo:        node.NodeInfo,
+			Connected:       node.IsConnected(),
+			ProtocolVersion: version,
+			Capabilities:    capabilities,
+			Link:            node.LinkQuality(),
+		})
+	}
+
+	return response
+}
+
+func (h *APIHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, SessionId)
+
+	uiSession, ok := h.sessions.FindUISession(id)
+
+	if !ok {
+		api_internal.EncodeError(w, r, diagnostics.AsNotFound(fmt.Errorf("unknown sessionId: %s", id)))
+		return
 	}
 
+	response := newSessionResponse(uiSession)
+
 	jsonData, err := json.Marshal(response)
 	if err != nil {
 		fmt.Fprintf(w, "Unable to create session: %v", err)
@@ -122,11 +295,71 @@ func (h *APIHandler) Log(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if acceptsEventStream(r) {
+		streamLog(w, r, client, file, offset, limit)
+		return
+	}
+
 	download := r.URL.Query().Get("download")
 
 	client.Log(r.Context(), w, file, offset, limit, len(download) > 0)
 }
 
+// logEvent is the data of a log stream event, the chunk is base64 encoded in JSON
+// so that the log arrives byte for byte, carriage returns included
+type logEvent struct {
+	Offset int64  `json:"offset"`
+	Chunk  []byte `json:"chunk"`
+}
+
+// streamLog sends the log file as data events of logEvent, the id of each event is
+// the offset following its chunk so the stream resumes where the client left off
+func streamLog(w http.ResponseWriter, r *http.Request, client erigon_node.Client, file string, offset int64, limit int64) {
+	end := offset + limit
+
+	if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+		resume, err := strconv.ParseInt(lastEventId, 10, 64)
+
+		if err != nil || resume < 0 {
+			http.Error(w, fmt.Sprintf("Last-Event-ID %s is not a log offset", lastEventId), http.StatusBadRequest)
+			return
+		}
+
+		offset = resume
+	}
+
+	bounded := limit > 0
+
+	if bounded {
+		limit = end - offset
+	}
+
+	stream, ok := newEventStream(w, r)
+
+	if !ok {
+		return
+	}
+
+	if bounded && limit <= 0 {
+		stream.end(strconv.FormatInt(offset, 10))
+		return
+	}
+
+	err := client.LogChunks(r.Context(), file, offset, limit, func(content erigon_node.LogContent) error {
+		offset = content.Offset + int64(len(content.Chunk))
+		return stream.send(EventData, strconv.FormatInt(offset, 10), logEvent{Offset: content.Offset, Chunk: content.Chunk})
+	})
+
+	if err != nil {
+		if r.Context().Err() == nil {
+			stream.fail(strconv.FormatInt(offset, 10), err)
+		}
+		return
+	}
+
+	stream.end(strconv.FormatInt(offset, 10))
+}
+
 func (h *APIHandler) Tables(w http.ResponseWriter, r *http.Request) {
 	db, tables := path.Split(chi.URLParam(r, "*"))
 
@@ -153,12 +386,153 @@ func (h *APIHandler) Tables(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		api_internal.EncodeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+type TableRow struct {
+	Key         string                  `json:"key"`
+	Value       string                  `json:"value"`
+	Decoded     *erigon_node.DecodedRow `json:"decoded,omitempty"`
+	DecodeError string                  `json:"decode_error,omitempty"`
+}
+
+type TableResponse struct {
+	Db       string     `json:"db"`
+	Table    string     `json:"table"`
+	Encoding string     `json:"encoding"`
+	Rows     []TableRow `json:"rows"`
+	NextKey  string     `json:"next_key,omitempty"`
+}
+
+const (
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+)
+
+func (h *APIHandler) Table(w http.ResponseWriter, r *http.Request) {
+	db := chi.URLParam(r, "db")
+	table := chi.URLParam(r, "table")
+
+	query, encoding, encode, err := parseTableQuery(r.URL.Query())
+
+	if err != nil {
+		api_internal.EncodeError(w, r, err)
+		return
+	}
+
+	client, err := h.findNodeClient(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := client.Table(r.Context(), db, table, query)
+
+	if err != nil {
+		api_internal.EncodeError(w, r, err)
+		return
+	}
+
+	response := newTableResponse(db, table, encoding, encode, results)
+
+	jsonData, err := json.Marshal(response)
+
+	if err != nil {
+		api_internal.EncodeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonData)
 }
 
+// parseTableQuery reads a table query from the request parameters, returning it with the
+// encoding of its keys and the function to encode the keys and values of the results with
+func parseTableQuery(params url.Values) (erigon_node.TableQuery, string, func([]byte) string, error) {
+	var query erigon_node.TableQuery
+
+	encoding := params.Get("encoding")
+
+	if encoding == "" {
+		encoding = EncodingHex
+	}
+
+	var encode func([]byte) string
+	var decode func(string) ([]byte, error)
+
+	switch encoding {
+	case EncodingHex:
+		encode, decode = hex.EncodeToString, hex.DecodeString
+	case EncodingBase64:
+		encode, decode = base64.URLEncoding.EncodeToString, base64.URLEncoding.DecodeString
+	default:
+		return query, encoding, nil, diagnostics.AsBadRequestErr(fmt.Errorf("unsupported encoding %q: expected %s or %s", encoding, EncodingHex, EncodingBase64))
+	}
+
+	for param, key := range map[string]*[]byte{
+		"start":  &query.StartKey,
+		"end":    &query.EndKey,
+		"prefix": &query.Prefix,
+	} {
+		value, err := decode(params.Get(param))
+
+		if err != nil {
+			return query, encoding, nil, diagnostics.AsBadRequestErr(fmt.Errorf("%s is not a valid %s key: %w", param, encoding, err))
+		}
+
+		*key = value
+	}
+
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+
+		if err != nil || limit < 0 {
+			return query, encoding, nil, diagnostics.AsBadRequestErr(fmt.Errorf("limit %s must be a non-negative number", limitStr))
+		}
+
+		query.Limit = limit
+	}
+
+	return query, encoding, encode, nil
+}
+
+// newTableResponse encodes the rows of the results, decoding them when the table has a decoder
+func newTableResponse(db string, table string, encoding string, encode func([]byte) string, results erigon_node.Results) TableResponse {
+	response := TableResponse{
+		Db:       db,
+		Table:    table,
+		Encoding: encoding,
+		Rows:     make([]TableRow, 0, len(results.Rows)),
+	}
+
+	decoder, decodable := erigon_node.FindTableDecoder(db, table)
+
+	for _, row := range results.Rows {
+		tableRow := TableRow{Key: encode(row.Key), Value: encode(row.Value)}
+
+		if decodable {
+			if decoded, err := decoder(row.Key, row.Value); err != nil {
+				tableRow.DecodeError = err.Error()
+			} else {
+				tableRow.Decoded = &decoded
+			}
+		}
+
+		response.Rows = append(response.Rows, tableRow)
+	}
+
+	if results.NextKey != nil {
+		response.NextKey = encode(results.NextKey)
+	}
+
+	return response
+}
+
 func (h *APIHandler) ReOrg(w http.ResponseWriter, r *http.Request) {
 	client, err := h.findNodeClient(r)
 
@@ -167,21 +541,69 @@ func (h *APIHandler) ReOrg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reorgs, err := client.FindReorgs(r.Context(), w)
+	var blocks uint64
+
+	if blocksStr := r.URL.Query().Get("blocks"); blocksStr != "" {
+		blocks, err = strconv.ParseUint(blocksStr, 10, 64)
+
+		if err != nil {
+			http.Error(w, fmt.Sprintf("blocks %s is not a Uint64 number: %v", blocksStr, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if acceptsEventStream(r) {
+		streamReorgs(w, r, client, blocks)
+		return
+	}
+
+	// the errors met while scanning are in the result, FindReorgs would write them ahead of it
+	reorgs, err := client.ScanReorgs(r.Context(), blocks, nil)
+
 	if err != nil {
 		api_internal.EncodeError(w, r, err)
+		return
 	}
 
 	jsonData, err := json.Marshal(reorgs)
 
 	if err != nil {
 		api_internal.EncodeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonData)
 }
 
+// streamReorgs sends progress events while the reorg scan runs, followed by the
+// result as a data event. A scan can not be resumed, so its events are numbered
+// sequentially
+func streamReorgs(w http.ResponseWriter, r *http.Request, client erigon_node.Client, blocks uint64) {
+	stream, ok := newEventStream(w, r)
+
+	if !ok {
+		return
+	}
+
+	reorgs, err := client.ScanReorgs(r.Context(), blocks, func(progress erigon_node.ReorgProgress) {
+		_ = stream.send(EventProgress, stream.nextId(), progress)
+	})
+
+	if err != nil {
+		if r.Context().Err() == nil {
+			stream.fail(stream.nextId(), err)
+		}
+		return
+	}
+
+	if err := stream.send(EventData, stream.nextId(), reorgs); err != nil {
+		return
+	}
+
+	stream.end(stream.nextId())
+}
+
 func (h *APIHandler) BodiesDownload(w http.ResponseWriter, r *http.Request) {
 	client, err := h.findNodeClient(r)
 
@@ -190,7 +612,10 @@ func (h *APIHandler) BodiesDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client.BodiesDownload(r.Context(), w)
+	serveDownloadFrames(w, r, func(ctx context.Context) (interface{}, int64, error) {
+		frame, err := client.BodiesDownload(ctx)
+		return frame, frame.Tick, err
+	})
 }
 
 func (h *APIHandler) HeadersDownload(w http.ResponseWriter, r *http.Request) {
@@ -201,7 +626,75 @@ func (h *APIHandler) HeadersDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client.HeadersDownload(r.Context(), w)
+	serveDownloadFrames(w, r, func(ctx context.Context) (interface{}, int64, error) {
+		frame, err := client.HeadersDownload(ctx)
+		return frame, frame.Tick, err
+	})
+}
+
+// downloadFrameInterval is how often the download state is polled when it is streamed
+const downloadFrameInterval = time.Second
+
+// serveDownloadFrames writes the current download state as JSON or, when the client
+// accepts text/event-stream, polls the node and sends a data event each time the state
+// changes until the request is done. The id of each event is the tick of its frame, so
+// a resumed stream skips the frame the client already has
+func serveDownloadFrames(w http.ResponseWriter, r *http.Request, poll func(ctx context.Context) (interface{}, int64, error)) {
+	if !acceptsEventStream(r) {
+		frame, _, err := poll(r.Context())
+
+		if err != nil {
+			api_internal.EncodeError(w, r, err)
+			return
+		}
+
+		jsonData, err := json.Marshal(frame)
+
+		if err != nil {
+			api_internal.EncodeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+		return
+	}
+
+	stream, ok := newEventStream(w, r)
+
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(downloadFrameInterval)
+	defer ticker.Stop()
+
+	lastTick := stream.lastEventId
+
+	for {
+		frame, tick, err := poll(r.Context())
+
+		if err != nil {
+			if r.Context().Err() == nil {
+				stream.fail(lastTick, err)
+			}
+			return
+		}
+
+		if id := strconv.FormatInt(tick, 10); id != lastTick {
+			if err := stream.send(EventData, id, frame); err != nil {
+				return
+			}
+
+			lastTick = id
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func (h *APIHandler) SyncStages(w http.ResponseWriter, r *http.Request) {
@@ -239,10 +732,10 @@ func (h *APIHandler) findNodeClient(r *http.Request) (erigon_node.Client, error)
 		return nil, fmt.Errorf("unknown nodeId: %s", nodeId)
 	}
 
-	for _, sid := range session.UISessions {
-		if sid == sessionId {
-			return session.Client, nil
-		}
+	if session.HasUISession(sessionId) {
+		// keeps the UI session from expiring while its nodes are in use
+		h.sessions.FindUISession(sessionId)
+		return session.Client, nil
 	}
 
 	return nil, fmt.Errorf("unknown sessionId: %s", sessionId)
@@ -298,24 +791,37 @@ func GetResponseData(ctx context.Context, client erigon_node.Client, request str
 
 func NewAPIHandler(
 	sessions sessions.CacheService,
+	tokens *sessions.TokenSigner,
 	erigonNode erigon_node.Client,
 ) *APIHandler {
 	r := &APIHandler{
 		Router:     chi.NewRouter(),
 		sessions:   sessions,
+		tokens:     tokens,
 		erigonNode: erigonNode,
+		logins:     newThrottle(loginFailuresPerClient, loginFailures),
+		issues:     newThrottle(sessionIssuesPerClient, sessionIssues),
 	}
 
+	r.Post("/login", r.Login)
+	r.Post("/sessions", r.IssueSession)
 	r.Get("/sessions/{sessionId}", r.GetSession)
+	r.Delete("/sessions/{sessionId}", r.RevokeSession)
 
 	// Erigon Node data
 	r.Get("/v2/sessions/{sessionId}/nodes/{nodeId}/ws", r.HandleWebSocket)
 	r.Get("/sessions/{sessionId}/nodes/{nodeId}/logs/{file}", r.Log)
+	r.Get("/sessions/{sessionId}/nodes/{nodeId}/dbs/{db}/tables/{table}", r.Table)
 	r.Get("/sessions/{sessionId}/nodes/{nodeId}/dbs/*", r.Tables)
 	r.Get("/sessions/{sessionId}/nodes/{nodeId}/reorgs", r.ReOrg)
 	r.Get("/sessions/{sessionId}/nodes/{nodeId}/bodies/download-summary", r.BodiesDownload)
 	r.Get("/sessions/{sessionId}/nodes/{nodeId}/headers/download-summary", r.HeadersDownload)
 	r.Get("/sessions/{sessionId}/nodes/{nodeId}/sync-stages", r.SyncStages)
+
+	for method, get := range nodeDataMethods {
+		r.Get("/sessions/{sessionId}/nodes/{nodeId}/"+method, r.serveNodeData(get))
+	}
+
 	r.Get("/v2/sessions/{sessionId}/nodes/{nodeId}/*", r.UniversalRequest)
 
 	return r
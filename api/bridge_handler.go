@@ -1,12 +1,15 @@
 package api
 
 import (
+	"compress/flate"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -31,7 +34,13 @@ const (
 	wsWriteBuffer      = 1024
 	wsPingInterval     = 60 * time.Second
 	wsPingWriteTimeout = 5 * time.Second
+	wsPongWait         = wsPingInterval + 30*time.Second // after which a silent connection is taken to be lost
 	wsMessageSizeLimit = 32 * 1024 * 1024
+	wsResultSizeLimit  = 512 * 1024 * 1024  // of a result reassembled from chunks
+	wsResultsSizeLimit = 1024 * 1024 * 1024 // of all the results of a connection being reassembled
+	wsCompressionLevel = flate.BestSpeed
+
+	bridgeHandshakeTimeout = 30 * time.Second
 )
 
 var wsBufferPool = new(sync.Pool)
@@ -60,6 +69,13 @@ func (h BridgeHandler) Bridge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	defer conn.Close()
+
+	// nodes send results larger than a message in chunks, and compress messages when
+	// they offered permessage-deflate in the upgrade, which the upgrader accepts
+	conn.SetReadLimit(wsMessageSizeLimit)
+	conn.SetCompressionLevel(wsCompressionLevel)
+
 	connectionInfo := struct {
 		Version  uint64               `json:"version"`
 		Sessions []string             `json:"sessions"`
@@ -81,11 +97,123 @@ func (h BridgeHandler) Bridge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestMap := map[string]*erigon_node.NodeRequest{}
-	requestMutex := sync.Mutex{}
+	resumeToken, err := sessions.NewResumeToken()
+
+	if err != nil {
+		log.Printf("Error creating bridge resume token: %v\n", err)
+		return
+	}
+
+	handshake, version, err := h.handshake(conn, connectionInfo.Version, connectionInfo.Sessions, connectionInfo.Nodes, resumeToken)
+
+	if err != nil {
+		log.Printf("Bridge handshake with %s failed: %v\n", r.RemoteAddr, err)
+		return
+	}
+
+	if version < sessions.BridgeProtocolV2 {
+		// the node has not been told the token, so can not resume
+		resumeToken = ""
+	}
+
+	// closeBridge is used when the node resumes over a new connection before this one is noticed to be lost
+	closeBridge := func() {
+		cancel()
+		conn.Close()
+	}
+
+	// the node goroutines and request cancellations write concurrently, a websocket allows one writer
+	writeMutex := sync.Mutex{}
+	write := func(message []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return conn.WriteMessage(websocket.TextMessage, message)
+	}
+
+	pending := newPendingRequests()
+
+	// cancel tells the node to abandon a request which the caller stopped waiting for,
+	// nodes speaking the first version of the protocol do not understand cancellations
+	cancelRequest := func(request *erigon_node.NodeRequest) {
+		if _, ok := pending.complete(request.Request.Id); !ok || version < sessions.BridgeProtocolV2 {
+			return
+		}
+
+		bytes, err := json.Marshal(&erigon_node.Request{
+			Method: erigon_node.CancelMethod,
+			Id:     request.Request.Id,
+			Params: &erigon_node.Params{NodeId: request.Request.Params.NodeId},
+		})
+
+		if err == nil {
+			err = write(bytes)
+		}
+
+		if err != nil {
+			log.Printf("Error cancelling request %s: %v\n", request.Request.Id, err)
+		}
+	}
+
+	// send writes the request to the node, it returns false when the connection is lost,
+	// leaving the request pending to be resent if the node resumes the connection
+	send := func(request *erigon_node.NodeRequest) bool {
+		bytes, err := json.Marshal(request.Request)
+
+		if err != nil {
+			request.Deliver(ctx, &erigon_node.Response{
+				Last: true,
+				Error: &erigon_node.Error{
+					Message: fmt.Errorf("failed to marshal request: %w", err).Error(),
+				},
+			})
+			return true
+		}
+
+		finished := pending.add(request)
+
+		if err := write(bytes); err != nil {
+			log.Printf("Error writing request to %s: %v\n", r.RemoteAddr, err)
+			closeBridge()
+			return false
+		}
+
+		go func() {
+			select {
+			case <-request.Done():
+				cancelRequest(request)
+			case <-finished:
+			case <-ctx.Done():
+			}
+		}()
+
+		return true
+	}
 
+	connections := map[*sessions.NodeSession]uint64{}
+	var linked []*sessions.NodeSession
 	wg := &sync.WaitGroup{}
-	defer wg.Wait()
+
+	defer func() {
+		// the node goroutines stop before their nodes are suspended or disconnected
+		cancel()
+		wg.Wait()
+
+		for nodeSession, connection := range connections {
+			inFlight := pending.take(nodeSession.NodeInfo.Id)
+
+			if !nodeSession.Suspend(connection, inFlight) {
+				nodeSession.Disconnect(connection)
+
+				for _, request := range inFlight {
+					request.Fail(erigon_node.NodeDisconnectedError())
+				}
+			}
+		}
+
+		// once the connection is lost none of the requests written to it can be answered
+		pending.failAll(erigon_node.NodeDisconnectedError())
+	}()
+
 	for _, node := range connectionInfo.Nodes {
 		nodeSession, ok := h.cache.FindNodeSession(node.Id)
 
@@ -100,14 +228,30 @@ func (h BridgeHandler) Bridge(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if len(handshake.PublicKey) > 0 {
+			if err := nodeSession.PinPublicKey(handshake.PublicKey); err != nil {
+				log.Printf("Error pinning node public key: %v\n", err)
+			}
+		}
+
+		// the requests which were in flight when the node's previous connection was lost are resent
+		resumed, _ := nodeSession.Resume(handshake.ResumeToken)
+
+		nodeSession.SetProtocol(version, handshake.Capabilities)
 		nodeSession.AttachSessions(connectionInfo.Sessions)
 
-		nodeSession.Connect(r.RemoteAddr)
+		connections[nodeSession] = nodeSession.Connect(r.RemoteAddr, resumeToken, closeBridge)
+		linked = append(linked, nodeSession)
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			defer nodeSession.Disconnect()
+
+			for _, request := range resumed {
+				if !send(request) {
+					return
+				}
+			}
 
 			for {
 				var request *erigon_node.NodeRequest
@@ -116,59 +260,123 @@ func (h BridgeHandler) Bridge(w http.ResponseWriter, r *http.Request) {
 				case <-ctx.Done():
 					return
 				}
-				rpcRequest := request.Request
-
-				bytes, err := json.Marshal(rpcRequest)
-
-				if err != nil {
-					request.Responses <- &erigon_node.Response{
-						Last: true,
-						Error: &erigon_node.Error{
-							Message: fmt.Errorf("failed to marshal request: %w", err).Error(),
-						},
-					}
-					continue
-				}
 
-				requestMutex.Lock()
-				requestMap[rpcRequest.Id] = request
-				requestMutex.Unlock()
-
-				if err := conn.WriteMessage(websocket.TextMessage, bytes); err != nil {
-					requestMutex.Lock()
-					delete(requestMap, rpcRequest.Id)
-					requestMutex.Unlock()
-					request.Retries++
-					if request.Retries < 15 {
-						select {
-						case nodeSession.RequestCh <- request:
-						default:
-						}
-					} else {
-						request.Responses <- &erigon_node.Response{
-							Last: true,
-							Error: &erigon_node.Error{
-								Message: fmt.Errorf("failed to write metrics request: %w", err).Error(),
-							},
-						}
-					}
-					continue
+				if !send(request) {
+					return
 				}
 			}
 		}()
 	}
 
+	// pings detect a lost connection, which would otherwise go unnoticed until it is next
+	// written to, and measure the round trip over the link, apart from the node's work
+	var pingSent atomic.Int64 // unix nanos of the unanswered ping, 0 once answered
+
+	conn.SetPongHandler(func(data string) error {
+		now := time.Now()
+		conn.SetReadDeadline(now.Add(wsPongWait))
+
+		if len(data) != 8 {
+			return nil
+		}
+
+		sent := int64(binary.BigEndian.Uint64([]byte(data)))
+
+		if pingSent.CompareAndSwap(sent, 0) {
+			for _, nodeSession := range linked {
+				nodeSession.RecordPing(now, now.Sub(time.Unix(0, sent)), false)
+			}
+		}
+
+		return nil
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+
+			if sent := pingSent.Swap(now.UnixNano()); sent != 0 {
+				for _, nodeSession := range linked {
+					nodeSession.RecordPing(time.Unix(0, sent), 0, true)
+				}
+			}
+
+			ping := binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+
+			if err := conn.WriteControl(websocket.PingMessage, ping, now.Add(wsPingWriteTimeout)); err != nil {
+				log.Printf("Error pinging %s: %v\n", r.RemoteAddr, err)
+				closeBridge()
+				return
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+	// results too large for one message, or of raw bytes, arrive in binary chunks from BridgeProtocolV3 on
+	chunks := erigon_node.NewChunkAssembler(wsResultSizeLimit, wsResultsSizeLimit)
+
 	for {
-		var response erigon_node.Response
+		response := &erigon_node.Response{}
 
-		_, message, err := conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 
 		if err != nil {
-			fmt.Printf("can't read response: %v\n", err)
-			continue
+			log.Printf("Bridge connection from %s closed: %v\n", r.RemoteAddr, err)
+			return
 		}
 
-		if err = json.Unmarshal(message, &response); err != nil {
+		now := time.Now()
+		conn.SetReadDeadline(now.Add(wsPongWait))
+
+		for _, nodeSession := range linked {
+			nodeSession.Seen(now)
+		}
+
+		if messageType == websocket.BinaryMessage {
+			if version < sessions.BridgeProtocolV3 {
+				log.Printf("Ignoring binary message from %s, which speaks protocol version %d\n", r.RemoteAddr, version)
+				continue
+			}
+
+			header, data, err := erigon_node.DecodeChunk(message)
+
+			if err != nil {
+				log.Printf("Error reading result chunk from %s: %v\n", r.RemoteAddr, err)
+				continue
+			}
+
+			if _, ok := pending.get(header.Id); !ok {
+				chunks.Discard(header.Id)
+				continue
+			}
+
+			if response = chunks.Add(header, data); response == nil {
+				continue
+			}
+
+			if response.Error != nil {
+				// the result is given up on, so the node is told to stop sending its chunks
+				if request, ok := pending.get(header.Id); ok {
+					cancelRequest(request)
+					request.Deliver(ctx, response)
+				}
+
+				continue
+			}
+		} else if err = json.Unmarshal(message, response); err != nil {
 			fmt.Printf("can't read response: %v\n", err)
 			fmt.Printf("Message: %s\n", string(message))
 			select {
@@ -180,26 +388,168 @@ func (h BridgeHandler) Bridge(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		requestMutex.Lock()
-		request, ok := requestMap[response.Id]
-		requestMutex.Unlock()
+		if response.Error != nil {
+			response.Last = true
+		}
+
+		var request *erigon_node.NodeRequest
+		var ok bool
+
+		if response.Last {
+			request, ok = pending.complete(response.Id)
+		} else {
+			request, ok = pending.get(response.Id)
+		}
 
 		if !ok {
 			continue
 		}
 
-		if response.Error != nil {
-			response.Last = true
+		if !request.Deliver(ctx, response) {
+			// the caller has gone, cancelRequest tells the node unless the request already completed
+			if !response.Last {
+				cancelRequest(request)
+			}
 		}
+	}
+}
 
-		request.Responses <- &response
+// pendingRequests tracks the requests written to a node until their last response
+// arrives, the caller stops waiting for them or the bridge connection is lost
+type pendingRequests struct {
+	mu       sync.Mutex
+	requests map[string]*pendingRequest
+}
 
-		if response.Last {
-			requestMutex.Lock()
-			delete(requestMap, response.Id)
-			requestMutex.Unlock()
+type pendingRequest struct {
+	request  *erigon_node.NodeRequest
+	finished chan struct{}
+}
+
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{requests: map[string]*pendingRequest{}}
+}
+
+// add tracks the request, the returned channel is closed when it completes
+func (p *pendingRequests) add(request *erigon_node.NodeRequest) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := &pendingRequest{request: request, finished: make(chan struct{})}
+	p.requests[request.Request.Id] = pending
+	return pending.finished
+}
+
+func (p *pendingRequests) get(id string) (*erigon_node.NodeRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.requests[id]
+
+	if !ok {
+		return nil, false
+	}
+
+	return pending.request, true
+}
+
+// take stops tracking the requests to the given node, returning them
+func (p *pendingRequests) take(nodeId string) []*erigon_node.NodeRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var requests []*erigon_node.NodeRequest
+
+	for id, pending := range p.requests {
+		if params := pending.request.Request.Params; params != nil && params.NodeId == nodeId {
+			delete(p.requests, id)
+			close(pending.finished)
+			requests = append(requests, pending.request)
 		}
 	}
+
+	return requests
+}
+
+// complete stops tracking the request, it returns false if it was not pending
+func (p *pendingRequests) complete(id string) (*erigon_node.NodeRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.requests[id]
+
+	if !ok {
+		return nil, false
+	}
+
+	delete(p.requests, id)
+	close(pending.finished)
+	return pending.request, true
+}
+
+// failAll completes every pending request with the error, without waiting for their callers to receive it
+func (p *pendingRequests) failAll(err *erigon_node.Error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, pending := range p.requests {
+		delete(p.requests, id)
+		close(pending.finished)
+
+		pending.request.Fail(err)
+	}
+}
+
+// handshake challenges the connecting node to prove it holds the bridge secrets of the sessions
+// it attaches to, before any of its nodes are attached, and agrees on the protocol version. Nodes
+// speaking BridgeProtocolV2 are given resumeToken to resume the connection with if it is lost.
+// The challenge nonce is fresh for every connection, so a recorded response can not be replayed
+func (h BridgeHandler) handshake(conn *websocket.Conn, announced uint64, sessionIds []string, nodes []*sessions.NodeInfo, resumeToken string) (sessions.HandshakeResponse, uint64, error) {
+	var response sessions.HandshakeResponse
+
+	challenge, err := sessions.NewBridgeChallenge()
+
+	if err != nil {
+		return response, 0, err
+	}
+
+	deadline := time.Now().Add(bridgeHandshakeTimeout)
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	defer conn.SetWriteDeadline(time.Time{})
+
+	if err := conn.WriteJSON(challenge); err != nil {
+		return response, 0, fmt.Errorf("sending challenge: %w", err)
+	}
+
+	if err := conn.ReadJSON(&response); err != nil {
+		return response, 0, fmt.Errorf("reading challenge response: %w", err)
+	}
+
+	version, err := sessions.NegotiateBridgeVersion(announced, response)
+
+	if err == nil {
+		err = h.cache.VerifyBridgeHandshake(challenge.Nonce, sessionIds, nodes, response)
+	}
+
+	if err != nil {
+		conn.WriteJSON(sessions.HandshakeMessage{Type: sessions.HandshakeRejected, Versions: sessions.SupportedBridgeVersions, Error: err.Error()})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "handshake rejected"), deadline)
+		return response, 0, err
+	}
+
+	accepted := sessions.HandshakeMessage{Type: sessions.HandshakeAccepted, Version: version}
+
+	if version >= sessions.BridgeProtocolV2 {
+		accepted.ResumeToken = resumeToken
+	}
+
+	if err := conn.WriteJSON(accepted); err != nil {
+		return response, 0, fmt.Errorf("sending handshake result: %w", err)
+	}
+
+	return response, version, nil
 }
 
 func NewBridgeHandler(cacheSvc sessions.CacheService) BridgeHandler {
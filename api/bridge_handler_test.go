@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+	"github.com/erigontech/diagnostics/internal/sessions"
+)
+
+const bridgeTestNode = "node"
+
+// bridgeTest is a bridge server with a UI session which the test plays the node of
+type bridgeTest struct {
+	url     string
+	cache   sessions.CacheService
+	session *sessions.UISession
+}
+
+func newBridgeTest(t *testing.T, resumeGrace time.Duration) *bridgeTest {
+	cache, err := sessions.NewCache(10, 10, sessions.WithBridgeResumeGrace(resumeGrace))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := cache.IssueUISession()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewBridgeHandler(cache))
+	t.Cleanup(server.Close)
+
+	return &bridgeTest{url: "ws" + strings.TrimPrefix(server.URL, "http"), cache: cache, session: session}
+}
+
+// connect opens a bridge connection for the node which resumes the one of resumeToken,
+// if given, returning the connection with the token to resume it in turn
+func (b *bridgeTest) connect(t *testing.T, resumeToken string) (*websocket.Conn, string) {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(b.url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	sessionId := strconv.FormatUint(b.session.SessionPin, 10)
+
+	connectionInfo := map[string]interface{}{
+		"version":  sessions.BridgeProtocolV2,
+		"sessions": []string{sessionId},
+		"nodes":    []*sessions.NodeInfo{{Id: bridgeTestNode}},
+	}
+
+	if err := conn.WriteJSON(connectionInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	var challenge sessions.HandshakeMessage
+
+	if err := conn.ReadJSON(&challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := sessions.HandshakePayload(challenge.Nonce, []string{sessionId}, []string{bridgeTestNode})
+
+	response := sessions.HandshakeResponse{
+		Macs:         map[string][]byte{sessionId: sessions.HandshakeMac(b.session.BridgeSecret, payload)},
+		Version:      sessions.BridgeProtocolV2,
+		Capabilities: []string{"dbs"},
+		ResumeToken:  resumeToken,
+	}
+
+	if err := conn.WriteJSON(response); err != nil {
+		t.Fatal(err)
+	}
+
+	var accepted sessions.HandshakeMessage
+
+	if err := conn.ReadJSON(&accepted); err != nil {
+		t.Fatal(err)
+	}
+
+	if accepted.Type != sessions.HandshakeAccepted || accepted.ResumeToken == "" {
+		t.Fatalf("handshake got %+v", accepted)
+	}
+
+	return conn, accepted.ResumeToken
+}
+
+// nodeSession waits for the node to be connected, or disconnected, returning its session
+func (b *bridgeTest) nodeSession(t *testing.T, connected bool) *sessions.NodeSession {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if session, ok := b.cache.FindNodeSession(bridgeTestNode); ok && session.IsConnected() == connected {
+			return session
+		}
+	}
+
+	t.Fatalf("node is not connected = %v", connected)
+	return nil
+}
+
+// ask asks the node for the method, the result arrives on the returned channel
+func ask(client erigon_node.Client, method string) <-chan error {
+	result := make(chan error, 1)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		response, err := client.GetResponse(ctx, method)
+
+		if err == nil && fmt.Sprint(response) != "[chaindata]" {
+			err = fmt.Errorf("got %v", response)
+		}
+
+		result <- err
+	}()
+
+	return result
+}
+
+// readRequest reads the next request the node is sent
+func readRequest(t *testing.T, conn *websocket.Conn) erigon_node.Request {
+	t.Helper()
+
+	var request erigon_node.Request
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.ReadJSON(&request); err != nil {
+		t.Fatal(err)
+	}
+
+	return request
+}
+
+func answer(t *testing.T, conn *websocket.Conn, request erigon_node.Request) {
+	t.Helper()
+
+	if err := conn.WriteJSON(erigon_node.Response{Id: request.Id, Result: []byte(`["chaindata"]`), Last: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not complete")
+		return nil
+	}
+}
+
+func TestBridgeFailsRequestsOnDisconnect(t *testing.T) {
+	b := newBridgeTest(t, 0)
+	conn, _ := b.connect(t, "")
+	client := b.nodeSession(t, true).Client
+
+	answered := ask(client, "dbs")
+	answer(t, conn, readRequest(t, conn))
+
+	if err := waitResult(t, answered); err != nil {
+		t.Fatalf("answered request failed: %v", err)
+	}
+
+	inFlight := ask(client, "dbs")
+	readRequest(t, conn)
+	conn.Close()
+
+	if err := waitResult(t, inFlight); !errors.Is(err, erigon_node.ErrNodeDisconnected) {
+		t.Fatalf("in-flight request got %v, want it to fail as the node disconnected", err)
+	}
+
+	b.nodeSession(t, false)
+
+	if err := waitResult(t, ask(client, "dbs")); !errors.Is(err, erigon_node.ErrNodeDisconnected) {
+		t.Fatalf("request to the disconnected node got %v", err)
+	}
+}
+
+func TestBridgeResume(t *testing.T) {
+	b := newBridgeTest(t, time.Minute)
+	conn, token := b.connect(t, "")
+	client := b.nodeSession(t, true).Client
+
+	inFlight := ask(client, "dbs")
+	lost := readRequest(t, conn)
+	conn.Close()
+	b.nodeSession(t, false)
+
+	// made while the node is away, it waits for the node to resume
+	waiting := ask(client, "dbs")
+
+	conn, _ = b.connect(t, token)
+	nodeSession := b.nodeSession(t, true)
+
+	if !nodeSession.HasUISession(strconv.FormatUint(b.session.SessionPin, 10)) {
+		t.Fatal("resumed node is not attached to its session")
+	}
+
+	for i := 0; i < 2; i++ {
+		request := readRequest(t, conn)
+
+		if i == 0 && request.Id != lost.Id {
+			t.Fatalf("resumed connection was first sent request %s, want the lost %s resent", request.Id, lost.Id)
+		}
+
+		answer(t, conn, request)
+	}
+
+	for _, result := range []<-chan error{inFlight, waiting} {
+		if err := waitResult(t, result); err != nil {
+			t.Fatalf("request failed across the resumed connection: %v", err)
+		}
+	}
+}
+
+func TestBridgeResumeAfterGrace(t *testing.T) {
+	b := newBridgeTest(t, 100*time.Millisecond)
+	conn, token := b.connect(t, "")
+	client := b.nodeSession(t, true).Client
+
+	inFlight := ask(client, "dbs")
+	lost := readRequest(t, conn)
+	conn.Close()
+
+	if err := waitResult(t, inFlight); !errors.Is(err, erigon_node.ErrNodeDisconnected) {
+		t.Fatalf("request in flight past the grace got %v, want it to fail as the node disconnected", err)
+	}
+
+	// the node connects afresh, its lost request is not resent
+	conn, _ = b.connect(t, token)
+	b.nodeSession(t, true)
+
+	next := ask(client, "dbs")
+	request := readRequest(t, conn)
+
+	if request.Id == lost.Id {
+		t.Fatalf("request %s was resent after the grace", lost.Id)
+	}
+
+	answer(t, conn, request)
+
+	if err := waitResult(t, next); err != nil {
+		t.Fatalf("request to the reconnected node failed: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Types of the events written to a Server-Sent Events stream
+const (
+	EventData     = "data"
+	EventProgress = "progress"
+	EventError    = "error"
+	EventEnd      = "end"
+)
+
+// acceptsEventStream returns true when the client asked for the response as Server-Sent Events
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// eventStream writes typed Server-Sent Events, flushing each one as it is sent.
+// Every event carries an id, so a client reconnecting with Last-Event-ID can resume
+// from the last event it received - what the id means is up to the endpoint
+type eventStream struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	lastEventId string
+	seq         uint64
+}
+
+// newEventStream starts an event stream response, it writes an error and
+// returns false when the ResponseWriter can not be flushed
+func newEventStream(w http.ResponseWriter, r *http.Request) (*eventStream, bool) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{
+		w:           w,
+		flusher:     flusher,
+		lastEventId: r.Header.Get("Last-Event-ID"),
+	}, true
+}
+
+// nextId returns the next id of a stream which numbers its events sequentially
+func (s *eventStream) nextId() string {
+	s.seq++
+	return fmt.Sprint(s.seq)
+}
+
+// send writes an event - strings are written as one data line per line of text and
+// anything else is encoded as JSON. A client joins the data lines with LF, so a string
+// comes back with LF line endings, content whose bytes matter is sent encoded as JSON
+func (s *eventStream) send(event string, id string, data interface{}) error {
+	var text string
+
+	switch data := data.(type) {
+	case nil:
+	case string:
+		text = data
+	default:
+		encoded, err := json.Marshal(data)
+
+		if err != nil {
+			return fmt.Errorf("encoding %s event: %w", event, err)
+		}
+
+		text = string(encoded)
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "event: %s\nid: %s\n", event, id)
+
+	for _, line := range splitLines(text) {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+
+	b.WriteString("\n")
+
+	if _, err := s.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+
+	return nil
+}
+
+// splitLines splits text on CRLF, CR and LF, each of which ends a field of an event
+func splitLines(text string) []string {
+	var lines []string
+
+	for {
+		i := strings.IndexAny(text, "\r\n")
+
+		if i < 0 {
+			return append(lines, text)
+		}
+
+		lines = append(lines, text[:i])
+
+		if strings.HasPrefix(text[i:], "\r\n") {
+			i++
+		}
+
+		text = text[i+1:]
+	}
+}
+
+// fail sends an error event, once a stream has started this replaces the HTTP error status
+func (s *eventStream) fail(id string, err error) {
+	_ = s.send(EventError, id, map[string]string{"message": err.Error()})
+}
+
+// end sends the event marking the normal end of the stream
+func (s *eventStream) end(id string) {
+	_ = s.send(EventEnd, id, nil)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventStreamSend(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{"no data", nil, "data: \n"},
+		{"one line", "text", "data: text\n"},
+		{"LF", "a\nb\n", "data: a\ndata: b\ndata: \n"},
+		{"CRLF", "a\r\nb", "data: a\ndata: b\n"},
+		{"CR", "a\rb\r\rc", "data: a\ndata: b\ndata: \ndata: c\n"},
+		{"JSON", map[string]string{"message": "a\r\nb"}, `data: {"message":"a\r\nb"}` + "\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			stream, ok := newEventStream(recorder, httptest.NewRequest("GET", "/", nil))
+
+			if !ok {
+				t.Fatal("no event stream")
+			}
+
+			if err := stream.send(EventData, "1", tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			if want := "event: data\nid: 1\n" + tt.want + "\n"; recorder.Body.String() != want {
+				t.Fatalf("sent %q, want %q", recorder.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestLogEventBytes(t *testing.T) {
+	chunk := []byte("line\r\nprogress 10%\rprogress 20%\r\n\xff")
+	recorder := httptest.NewRecorder()
+	stream, _ := newEventStream(recorder, httptest.NewRequest("GET", "/", nil))
+
+	if err := stream.send(EventData, "1", logEvent{Offset: 5, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+
+	var data string
+
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data += value
+		}
+	}
+
+	var event logEvent
+
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Offset != 5 || string(event.Chunk) != string(chunk) {
+		t.Fatalf("got %+v, want chunk %q", event, chunk)
+	}
+}
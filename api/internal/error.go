@@ -2,9 +2,12 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/erigontech/diagnostics"
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+	"github.com/erigontech/diagnostics/internal/sessions"
 )
 
 type Error struct {
@@ -14,23 +17,27 @@ type Error struct {
 	URI     string `json:"uri"`
 }
 
-func marshalError(r *http.Request, err error) Error {
-	message := err.Error()
-
-	var code int
+// StatusCode returns the HTTP status which reports the error
+func StatusCode(err error) int {
 	if diagnostics.IsNotFoundErr(err) {
-		code = http.StatusUnauthorized
+		return http.StatusUnauthorized
 	} else if diagnostics.IsBadRequestErr(err) {
-		code = http.StatusBadRequest
-
-	} else {
-		code = http.StatusInternalServerError
-		message = err.Error()
+		return http.StatusBadRequest
+	} else if errors.Is(err, erigon_node.ErrUnsupportedMethod) {
+		return http.StatusNotImplemented
+	} else if errors.Is(err, erigon_node.ErrNodeDisconnected) || errors.Is(err, sessions.ErrSessionsFull) {
+		return http.StatusServiceUnavailable
+	} else if errors.Is(err, erigon_node.ErrInvalidNodeData) {
+		return http.StatusBadGateway
 	}
 
+	return http.StatusInternalServerError
+}
+
+func marshalError(r *http.Request, err error) Error {
 	return Error{
-		Code:    code,
-		Message: message,
+		Code:    StatusCode(err),
+		Message: err.Error(),
 		Method:  r.Method,
 		URI:     r.URL.Path,
 	}
@@ -9,13 +9,19 @@ import (
 	"github.com/go-chi/cors"
 
 	"github.com/erigontech/diagnostics/api/internal"
+	"github.com/erigontech/diagnostics/internal/bridge"
 	"github.com/erigontech/diagnostics/internal/erigon_node"
 	"github.com/erigontech/diagnostics/internal/sessions"
 )
 
 type APIServices struct {
-	ErigonNode   erigon_node.Client
-	StoreSession sessions.CacheService
+	ErigonNode    erigon_node.Client
+	StoreSession  sessions.CacheService
+	SessionTokens *sessions.TokenSigner
+	// BridgeClientCert requires nodes to present a verified client certificate on the bridge
+	BridgeClientCert bool
+	// SeparateBridge leaves the bridge out of the UI and API handler, to be served by NewBridgeServerHandler
+	SeparateBridge bool
 }
 
 func NewHandler(services APIServices) http.Handler {
@@ -51,13 +57,15 @@ func NewHandler(services APIServices) http.Handler {
 		Route("Origin", "*", cors.Handler(cors.Options{
 			AllowedOrigins:   []string{"*"},
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Content-Type", "session-id"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "session-id"},
 			AllowCredentials: false, // <----------<<< do not allow credentials
 		})).
 		Handler)
 
 	r.Mount(internal.HealthCheckEndPoint, HealthCheckHandler())
-	r.Mount(internal.BridgeEndPoint, NewBridgeHandler(services.StoreSession))
+	if !services.SeparateBridge {
+		r.Mount(internal.BridgeEndPoint, newBridgeEndpoint(services))
+	}
 
 	assets, _ := erigonwatch.UIFiles()
 	fs := http.FileServer(http.FS(assets))
@@ -69,14 +77,37 @@ func NewHandler(services APIServices) http.Handler {
 	}
 
 	r.Group(func(r chi.Router) {
-		session := sessions.Middleware{CacheService: services.StoreSession}
+		session := sessions.Middleware{CacheService: services.StoreSession, Tokens: services.SessionTokens}
 		r.Use(session.Middleware)
-		r.Mount("/api", NewAPIHandler(services.StoreSession, services.ErigonNode))
+		r.Mount("/api", NewAPIHandler(services.StoreSession, services.SessionTokens, services.ErigonNode))
 	})
 
 	return r
 }
 
+// NewBridgeServerHandler serves only the bridge, for a listener which nodes connect to
+// separately from the operators using the UI and API
+func NewBridgeServerHandler(services APIServices) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(bridge.Middleware)
+
+	r.Mount(internal.HealthCheckEndPoint, HealthCheckHandler())
+	r.Mount(internal.BridgeEndPoint, newBridgeEndpoint(services))
+
+	return r
+}
+
+func newBridgeEndpoint(services APIServices) http.Handler {
+	var handler http.Handler = NewBridgeHandler(services.StoreSession)
+
+	if services.BridgeClientCert {
+		handler = bridge.RequireClientCert(handler)
+	}
+
+	return handler
+}
+
 func addhandler(r *chi.Mux, path string, handler http.Handler) {
 	r.Handle(path, http.StripPrefix(path, handler))
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/erigontech/diagnostics"
+	api_internal "github.com/erigontech/diagnostics/api/internal"
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+)
+
+type nodeDataFunc func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error)
+
+// nodeDataMethods fetch the typed, validated node data served at the path of the
+// same name below a node, and requested over the WebSocket with that method
+var nodeDataMethods = map[string]nodeDataFunc{
+	"peers": func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error) {
+		peerType := strings.ToLower(params.Get("type"))
+
+		if peerType != "" && peerType != erigon_node.PeerTypeSentry && peerType != erigon_node.PeerTypeSentinel {
+			return nil, diagnostics.AsBadRequestErr(fmt.Errorf("unknown peer type %q: expected %s or %s", peerType, erigon_node.PeerTypeSentry, erigon_node.PeerTypeSentinel))
+		}
+
+		peers, err := client.Peers(ctx)
+
+		if err != nil || peerType == "" {
+			return peers, err
+		}
+
+		return peers.OfType(peerType), nil
+	},
+	"downloader": func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error) {
+		return client.DownloaderStatus(ctx)
+	},
+	"sysinfo": func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error) {
+		return client.SysInfo(ctx)
+	},
+	"flags": func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error) {
+		return client.Flags(ctx)
+	},
+	"cmdline": func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error) {
+		return client.CommandLine(ctx)
+	},
+	"nodeinfo": func(ctx context.Context, client erigon_node.Client, params url.Values) (interface{}, error) {
+		return client.NodeInfo(ctx)
+	},
+}
+
+func (h *APIHandler) serveNodeData(get nodeDataFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := h.findNodeClient(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := get(r.Context(), client, r.URL.Query())
+
+		if err != nil {
+			api_internal.EncodeError(w, r, err)
+			return
+		}
+
+		jsonData, err := json.Marshal(data)
+
+		if err != nil {
+			api_internal.EncodeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+	"github.com/erigontech/diagnostics/internal/sessions"
+)
+
+// dataNode answers the requests of a node session with the result set for their method
+type dataNode struct {
+	lock    sync.Mutex
+	results map[string]string
+}
+
+func (n *dataNode) set(method string, result string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.results[method] = result
+}
+
+func (n *dataNode) serve(ctx context.Context, requests chan *erigon_node.NodeRequest) {
+	for {
+		select {
+		case request := <-requests:
+			n.lock.Lock()
+			result := n.results[request.Request.Method]
+			n.lock.Unlock()
+
+			request.Deliver(ctx, &erigon_node.Response{Id: request.Request.Id, Result: json.RawMessage(result), Last: true})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func TestServeNodeData(t *testing.T) {
+	cache, err := sessions.NewCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := cache.IssueUISession()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionId := strconv.FormatUint(session.SessionPin, 10)
+	nodeSession, err := cache.CreateNodeSession(&sessions.NodeInfo{Id: "node"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nodeSession.AttachSessions([]string{sessionId}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeSession.Client.SetConnected(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node := &dataNode{results: map[string]string{}}
+	go node.serve(ctx, nodeSession.RequestCh)
+
+	server := httptest.NewServer(NewAPIHandler(cache, nil, nil))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		method string // of the node, answered with result
+		result string
+		status int
+		want   string // body when the request succeeds
+	}{
+		{
+			name: "peers", path: "/sessions/" + sessionId + "/nodes/node/peers",
+			method: "peers", result: `[{"id":"a","type":"sentry"},{"id":"b","type":"sentinel"}]`,
+			status: http.StatusOK,
+		},
+		{
+			name: "peers of a type", path: "/sessions/" + sessionId + "/nodes/node/peers?type=Sentinel",
+			method: "peers", result: `[{"id":"a","type":"sentry"},{"id":"b","type":"sentinel"}]`,
+			status: http.StatusOK, want: "b",
+		},
+		{
+			name: "peers of an unknown type", path: "/sessions/" + sessionId + "/nodes/node/peers?type=light",
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "peers listed twice", path: "/sessions/" + sessionId + "/nodes/node/peers",
+			method: "peers", result: `[{"id":"a","type":"sentry"},{"id":"a","type":"sentry"}]`,
+			status: http.StatusBadGateway,
+		},
+		{
+			name: "flags", path: "/sessions/" + sessionId + "/nodes/node/flags",
+			method: "flags", result: `{"chain":"mainnet"}`,
+			status: http.StatusOK,
+		},
+		{
+			name: "malformed flags", path: "/sessions/" + sessionId + "/nodes/node/flags",
+			method: "flags", result: `["chain"]`,
+			status: http.StatusBadGateway,
+		},
+		{
+			name: "empty command line", path: "/sessions/" + sessionId + "/nodes/node/cmdline",
+			method: "cmdline", result: `[]`,
+			status: http.StatusBadGateway,
+		},
+		{
+			name: "downloader", path: "/sessions/" + sessionId + "/nodes/node/downloader",
+			method: "snapshot-sync", result: `{"snapshotDownload":{"downloaded":11,"total":10}}`,
+			status: http.StatusBadGateway,
+		},
+		{
+			name: "sysinfo", path: "/sessions/" + sessionId + "/nodes/node/sysinfo",
+			method: "hardware-info", result: `{"disk":{"total":10,"free":5}}`,
+			status: http.StatusOK,
+		},
+		{
+			name: "node info", path: "/sessions/" + sessionId + "/nodes/node/nodeinfo",
+			method: "nodeinfo", result: `[{"id":"a","enode":"enr:-abc"}]`,
+			status: http.StatusBadGateway,
+		},
+		{
+			name: "unknown node", path: "/sessions/" + sessionId + "/nodes/other/peers",
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "unknown session", path: "/sessions/" + unknownSessionId(sessionId) + "/nodes/node/peers",
+			status: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.method != "" {
+				node.set(tt.method, tt.result)
+			}
+
+			response, err := http.Get(server.URL + tt.path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.status, body)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if tt.want == "" {
+				// served as the node sent it, once decoded
+				if !jsonEqual(t, body, []byte(tt.result)) {
+					t.Fatalf("served %s, want %s", body, tt.result)
+				}
+
+				return
+			}
+
+			var peers erigon_node.Peers
+
+			if err := json.Unmarshal(body, &peers); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(peers) != 1 || peers[0].Id != tt.want {
+				t.Fatalf("served peers %+v, want %s alone", peers, tt.want)
+			}
+		})
+	}
+}
+
+// unknownSessionId returns a session id other than the given one
+func unknownSessionId(sessionId string) string {
+	if sessionId == "10000000" {
+		return "10000001"
+	}
+
+	return "10000000"
+}
+
+// jsonEqual returns true when got holds the fields of want, with the same values
+func jsonEqual(t *testing.T, got []byte, want []byte) bool {
+	t.Helper()
+
+	var gotValue, wantValue interface{}
+
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatal(err)
+	}
+
+	return contains(gotValue, wantValue)
+}
+
+func contains(got interface{}, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got, ok := got.(map[string]interface{})
+
+		if !ok {
+			return false
+		}
+
+		for key, value := range want {
+			if !contains(got[key], value) {
+				return false
+			}
+		}
+
+		return true
+	case []interface{}:
+		got, ok := got.([]interface{})
+
+		if !ok || len(got) != len(want) {
+			return false
+		}
+
+		for i := range want {
+			if !contains(got[i], want[i]) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return got == want
+	}
+}
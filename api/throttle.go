@@ -0,0 +1,135 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// throttleClients is the number of clients whose attempts are remembered, the
+// attempts of the least recent are forgotten once there are more
+const throttleClients = 10000
+
+// rateLimit allows burst attempts at once, then one attempt every interval
+type rateLimit struct {
+	burst    int
+	interval time.Duration
+}
+
+// throttle limits attempts, such as failed logins, by client and in total with token buckets
+type throttle struct {
+	lock    sync.Mutex
+	limit   rateLimit
+	clients *lru.Cache[string, *tokenBucket]
+	total   *tokenBucket
+}
+
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	filled time.Time // when tokens was last brought up to date
+}
+
+func newThrottle(perClient rateLimit, total rateLimit) *throttle {
+	clients, _ := lru.New[string, *tokenBucket](throttleClients)
+
+	return &throttle{
+		limit:   perClient,
+		clients: clients,
+		total:   newTokenBucket(total, time.Now()),
+	}
+}
+
+func newTokenBucket(limit rateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.burst), filled: now}
+}
+
+// wait returns how long until the bucket has a token, 0 when it has one
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if elapsed := now.Sub(b.filled); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.burst), b.tokens+float64(elapsed)/float64(b.limit.interval))
+		b.filled = now
+	}
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) * float64(b.limit.interval))
+}
+
+func (t *throttle) client(client string, now time.Time) *tokenBucket {
+	bucket, ok := t.clients.Get(client)
+
+	if !ok {
+		bucket = newTokenBucket(t.limit, now)
+		t.clients.Add(client, bucket)
+	}
+
+	return bucket
+}
+
+// wait returns how long the client has to wait before its next attempt, 0 when it may make one
+func (t *throttle) wait(client string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	return max(t.client(client, now).wait(now), t.total.wait(now))
+}
+
+// take counts an attempt of the client against its limit and the total limit
+func (t *throttle) take(client string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+
+	for _, bucket := range []*tokenBucket{t.client(client, now), t.total} {
+		bucket.wait(now)
+		bucket.tokens--
+	}
+}
+
+// allow takes an attempt for the client if neither it nor all clients together are over
+// their limit, otherwise it returns how long the client has to wait to be allowed one
+func (t *throttle) allow(client string) (time.Duration, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	buckets := []*tokenBucket{t.client(client, now), t.total}
+
+	if wait := max(buckets[0].wait(now), buckets[1].wait(now)); wait > 0 {
+		return wait, false
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+
+	return 0, true
+}
+
+// clientAddress identifies the client of the request by its IP address, forwarding headers
+// are not trusted as clients could then pose as any number of others
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// tooManyRequests tells the client to retry after wait
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many attempts, try again later", http.StatusTooManyRequests)
+}
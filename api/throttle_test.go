@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erigontech/diagnostics/internal/sessions"
+)
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(rateLimit{burst: 2, interval: time.Hour}, rateLimit{burst: 3, interval: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if wait := th.wait("a"); wait != 0 {
+			t.Fatalf("attempt %d of a waits %v", i, wait)
+		}
+
+		th.take("a")
+	}
+
+	if wait := th.wait("a"); wait <= 0 || wait > time.Hour {
+		t.Fatalf("a over its limit waits %v", wait)
+	}
+
+	if wait := th.wait("b"); wait != 0 {
+		t.Fatalf("b waits %v for a's attempts", wait)
+	}
+
+	th.take("b")
+
+	// the total limit holds whichever client the attempts come from
+	if wait := th.wait("c"); wait == 0 {
+		t.Fatal("c is not held to the total limit")
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	cache, err := sessions.NewCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := sessions.NewTokenSigner(nil, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewAPIHandler(cache, tokens, nil)
+
+	login := func(remoteAddr string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	uiSession, err := cache.IssueUISession()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := strconv.FormatUint(uiSession.SessionPin, 10)
+
+	var failed string
+
+	// unknown and malformed PINs can not be told apart
+	guesses := []string{"12345678", "x"}
+
+	for i := 0; i < loginFailuresPerClient.burst; i++ {
+		w := login("192.0.2.1:1000", `{"pin":"`+guesses[i%len(guesses)]+`"}`)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d got status %d", i, w.Code)
+		}
+
+		if failed != "" && w.Body.String() != failed {
+			t.Fatalf("failed logins differ: %s and %s", failed, w.Body.String())
+		}
+
+		failed = w.Body.String()
+	}
+
+	w := login("192.0.2.1:1001", `{"pin":"`+pin+`"}`)
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("login after too many failures got status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if w := login("192.0.2.2:1000", `{"pin":"`+pin+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("login from another client got status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIssueSessionLimits(t *testing.T) {
+	cache, err := sessions.NewCache(10, sessionIssuesPerClient.burst)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := sessions.NewTokenSigner(nil, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewAPIHandler(cache, tokens, nil)
+
+	issue := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/sessions", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	var first IssueSessionResponse
+
+	for i := 0; i < sessionIssuesPerClient.burst; i++ {
+		w := issue("192.0.2.1:1000")
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("issue %d got status %d: %s", i, w.Code, w.Body.String())
+		}
+
+		if i == 0 {
+			if err := json.Unmarshal(w.Body.Bytes(), &first); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if w := issue("192.0.2.1:1001"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("issue over the client's limit got status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// the cache is full, so another client is turned away rather than a live session evicted
+	if w := issue("192.0.2.2:1000"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("issue to a full cache got status %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, ok := cache.FindUISession(strconv.FormatUint(first.SessionPin, 10)); !ok {
+		t.Fatal("the first session issued was evicted")
+	}
+}
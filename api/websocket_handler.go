@@ -8,74 +8,275 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	api_internal "github.com/erigontech/diagnostics/api/internal"
+	"github.com/erigontech/diagnostics/internal/erigon_node"
 )
 
 const (
 	ActionSubscribe   = "subscribe"
 	ActionUnsubscribe = "unsubscribe"
+	ActionStart       = "start"
+	ActionCancel      = "cancel"
+	ActionRequest     = "request"
+
+	// wsSubscriptionBuffer holds the messages of a subscription waiting to be sent to the client
+	wsSubscriptionBuffer = 64
 )
 
 // SubscriptionResponse is the response sent back to the client after an action is processed.
+// Responses to a message with an id carry the same id
 type ClientResponse struct {
-	Status  string  `json:"status"`
-	Message string  `json:"message,omitempty"`
-	Data    *string `json:"data,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"` // numbers the messages sent to the client, except gap messages
+	Id      string          `json:"id,omitempty"`
+	Status  string          `json:"status"`
+	Service string          `json:"service,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Data    *string         `json:"data,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"` // of a request
+	Code    int             `json:"code,omitempty"`   // HTTP status of a failed request
+
+	Dropped   uint64 `json:"dropped,omitempty"`   // by a gap message, the number of messages dropped
+	Coalesced uint64 `json:"coalesced,omitempty"` // number of earlier values of the service this supersedes
 }
 
+// Policies for messages sent to a client faster than its connection takes them, chosen
+// with the overflow parameter of the WebSocket request
+const (
+	// OverflowBlock holds up the sender until the queue has room. Subscriptions shared with
+	// other clients are not held up, the messages they drop meanwhile are reported by a gap message
+	OverflowBlock = "block"
+	// OverflowDropOldest drops the oldest queued message, the client is sent a gap message
+	// with the number of messages dropped before the next message
+	OverflowDropOldest = "drop-oldest"
+	// OverflowCoalesce replaces the queued message of a service with its latest, counting the
+	// values it supersedes in Coalesced. Other messages are dropped as by OverflowDropOldest
+	OverflowCoalesce = "coalesce"
+
+	StatusGap = "gap"
+
+	wsWriteQueueSize = 200
+	wsWriteTimeout   = 10 * time.Second
+)
+
 type WebsocketHandler struct {
-	mu         sync.Mutex
-	writeQueue chan []byte
-	conn       *websocket.Conn
-	closeChan  chan struct{}
-	closed     bool
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	closeChan chan struct{}
+	closed    bool
+
+	// the queue of messages to write is guarded by queueLock, which queueCond waits with
+	queueLock sync.Mutex
+	queueCond *sync.Cond
+	queue     []*ClientResponse
+	overflow  string
+	seq       uint64 // of the last queued message
+	stopped   bool
 }
 
 // **NewWebsocketHandler initializes WebsocketHandler**
-func NewWebsocketHandler(conn *websocket.Conn) *WebsocketHandler {
+func NewWebsocketHandler(conn *websocket.Conn, overflow string) *WebsocketHandler {
 	handler := &WebsocketHandler{
-		writeQueue: make(chan []byte, 200),
-		conn:       conn,
-		closeChan:  make(chan struct{}),
-		closed:     false,
+		conn:      conn,
+		closeChan: make(chan struct{}),
+		closed:    false,
+		overflow:  overflow,
 	}
 
+	handler.queueCond = sync.NewCond(&handler.queueLock)
+
 	go handler.startWriter() // Start dedicated writer goroutine
 	return handler
 }
 
+// validOverflow returns true for the overflow policies
+func validOverflow(overflow string) bool {
+	switch overflow {
+	case OverflowBlock, OverflowDropOldest, OverflowCoalesce:
+		return true
+	}
+
+	return false
+}
+
 // **Sends response safely**
+// Responses are numbered in the order they are sent, so the client can tell when some were dropped
 func (h *WebsocketHandler) sendResponse(response *ClientResponse) {
-	resp, err := json.Marshal(response)
-	if err != nil {
-		fmt.Printf("Error marshaling response: %v\n", err)
+	h.sendAfterLost(response, 0)
+}
+
+// sendAfterLost sends the response of a service after the given number of its messages were
+// lost before reaching the handler, such as by a full subscription channel. They are numbered,
+// so the client sees them missing, and counted as superseded by the response when coalescing
+// or else reported by a gap message right before the response
+func (h *WebsocketHandler) sendAfterLost(response *ClientResponse, lost uint64) {
+	h.queueLock.Lock()
+	defer h.queueLock.Unlock()
+
+	if h.stopped {
 		return
 	}
 
-	select {
-	case h.writeQueue <- resp:
-	default:
-		fmt.Println("Warning: writeQueue is full, dropping message")
+	coalesce := h.overflow == OverflowCoalesce && coalesceKey(response) != ""
+
+	if coalesce {
+		response.Coalesced += lost
+		key := coalesceKey(response)
+
+		for i, queued := range h.queue {
+			if coalesceKey(queued) == key {
+				response.Coalesced += queued.Coalesced + 1
+				h.queue = append(h.queue[:i], h.queue[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// room returns the number of queue entries taken by the response and its gap message
+	room := func() int {
+		if lost > 0 && !coalesce && !isGap(h.last()) {
+			return 2
+		}
+
+		return 1
+	}
+
+	for h.overflow == OverflowBlock && len(h.queue)+room() > wsWriteQueueSize {
+		h.queueCond.Wait()
+
+		if h.stopped {
+			return
+		}
+	}
+
+	if len(h.queue)+room() > wsWriteQueueSize {
+		h.dropOldest(room())
+	}
+
+	if lost > 0 && !coalesce {
+		if isGap(h.last()) {
+			reportDropped(h.last(), lost)
+		} else {
+			h.queue = append(h.queue, reportDropped(nil, lost))
+		}
+	}
+
+	// numbered once queued, so the client is sent the messages in the order of their numbers
+	h.seq += lost + 1
+	response.Seq = h.seq
+
+	h.queue = append(h.queue, response)
+	h.queueCond.Broadcast()
+}
+
+// dropOldest drops the oldest queued messages until room more entries fit after the gap
+// message reporting them, which takes their place at the head of the queue
+func (h *WebsocketHandler) dropOldest(room int) {
+	var dropped uint64
+
+	for len(h.queue) > 0 && len(h.queue)+1+room > wsWriteQueueSize {
+		if isGap(h.queue[0]) {
+			dropped += h.queue[0].Dropped
+		} else {
+			dropped += 1 + h.queue[0].Coalesced // with the values it superseded
+		}
+
+		h.queue[0] = nil
+		h.queue = h.queue[1:]
+	}
+
+	if len(h.queue) > 0 && isGap(h.queue[0]) {
+		reportDropped(h.queue[0], dropped)
+		return
+	}
+
+	h.queue = append([]*ClientResponse{reportDropped(nil, dropped)}, h.queue...)
+}
+
+// last returns the latest queued message, if any
+func (h *WebsocketHandler) last() *ClientResponse {
+	if len(h.queue) == 0 {
+		return nil
+	}
+
+	return h.queue[len(h.queue)-1]
+}
+
+func isGap(response *ClientResponse) bool {
+	return response != nil && response.Status == StatusGap
+}
+
+// reportDropped counts n more dropped messages in the gap message, creating it if nil
+func reportDropped(gap *ClientResponse, n uint64) *ClientResponse {
+	if gap == nil {
+		gap = &ClientResponse{Status: StatusGap}
+	}
+
+	gap.Dropped += n
+	gap.Message = fmt.Sprintf("%d messages were dropped", gap.Dropped)
+
+	return gap
+}
+
+// coalesceKey returns the service whose latest value the response carries, if any
+func coalesceKey(response *ClientResponse) string {
+	if response.Id != "" || (response.Status != "success" && response.Status != StatusProgress) {
+		return ""
+	}
+
+	return response.Service
+}
+
+// nextResponse waits for the next message to write, gap messages included where messages
+// were dropped. It returns false once the connection is closed
+func (h *WebsocketHandler) nextResponse() (*ClientResponse, bool) {
+	h.queueLock.Lock()
+	defer h.queueLock.Unlock()
+
+	for len(h.queue) == 0 && !h.stopped {
+		h.queueCond.Wait()
+	}
+
+	if h.stopped {
+		return nil, false
 	}
+
+	response := h.queue[0]
+	h.queue[0] = nil
+	h.queue = h.queue[1:]
+	h.queueCond.Broadcast()
+
+	return response, true
 }
 
 // **Dedicated writer goroutine**
 func (h *WebsocketHandler) startWriter() {
-	for {
-		select {
-		case msg := <-h.writeQueue:
-			h.mu.Lock()
-			err := h.conn.WriteMessage(websocket.TextMessage, msg)
-			h.mu.Unlock()
+	defer h.closeConnection()
 
-			if err != nil {
-				fmt.Printf("Error writing response: %v\n", err)
-				return
-			}
+	for {
+		response, ok := h.nextResponse()
 
-		case <-h.closeChan:
+		if !ok {
 			fmt.Println("Writer goroutine stopped")
 			return
 		}
+
+		msg, err := json.Marshal(response)
+
+		if err != nil {
+			fmt.Printf("Error marshaling response: %v\n", err)
+			continue
+		}
+
+		h.mu.Lock()
+		h.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		err = h.conn.WriteMessage(websocket.TextMessage, msg)
+		h.mu.Unlock()
+
+		if err != nil {
+			fmt.Printf("Error writing response: %v\n", err)
+			return
+		}
 	}
 }
 
@@ -89,6 +290,12 @@ func (h *WebsocketHandler) closeConnection() {
 	h.closed = true
 	h.mu.Unlock()
 
+	h.queueLock.Lock()
+	h.stopped = true
+	h.queue = nil
+	h.queueCond.Broadcast()
+	h.queueLock.Unlock()
+
 	close(h.closeChan)
 	h.conn.Close()
 }
@@ -96,8 +303,20 @@ func (h *WebsocketHandler) closeConnection() {
 // **WebSocket handler function**
 func (h *APIHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	type wsMessage struct {
-		Service string `json:"service"`
-		Action  string `json:"action"`
+		Id      string            `json:"id,omitempty"`
+		Service string            `json:"service"`
+		Action  string            `json:"action"`
+		Method  string            `json:"method,omitempty"` // of a request
+		Params  map[string]string `json:"params,omitempty"`
+	}
+
+	overflow := r.URL.Query().Get("overflow")
+
+	if overflow == "" {
+		overflow = OverflowDropOldest
+	} else if !validOverflow(overflow) {
+		http.Error(w, fmt.Sprintf("unknown overflow policy %q: expected %s, %s or %s", overflow, OverflowBlock, OverflowDropOldest, OverflowCoalesce), http.StatusBadRequest)
+		return
 	}
 
 	upgrader := websocket.Upgrader{
@@ -111,29 +330,30 @@ func (h *APIHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	handler := NewWebsocketHandler(conn)
+	handler := NewWebsocketHandler(conn, overflow)
 	defer handler.closeConnection()
 
-	channel := make(chan []byte)
+	jobs := newWsJobs()
+	defer jobs.cancelAll()
+
+	// requests in progress, by id
+	requests := newWsJobs()
+	defer requests.cancelAll()
+
+	// the node's subscriptions are shared with the other sockets watching it
+	subscriptions := map[string]*erigon_node.Subscription{}
+
+	defer func() {
+		for _, subscription := range subscriptions {
+			subscription.Unsubscribe()
+		}
+	}()
 
-	// **Goroutine to forward messages from the channel to the client**
 	go func() {
-		for {
-			select {
-			case <-r.Context().Done():
-				handler.closeConnection()
-				return
-			case <-handler.closeChan: // Graceful shutdown
-				return
-			case message, ok := <-channel:
-				if !ok {
-					return
-				}
-				handler.sendResponse(&ClientResponse{
-					Status:  "success",
-					Message: string(message),
-				})
-			}
+		select {
+		case <-r.Context().Done():
+			handler.closeConnection()
+		case <-handler.closeChan: // Graceful shutdown
 		}
 	}()
 
@@ -156,7 +376,7 @@ func (h *APIHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 					handler.mu.Unlock()
 					return
 				}
-				err := conn.WriteMessage(websocket.PingMessage, nil)
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
 				handler.mu.Unlock()
 
 				if err != nil {
@@ -205,14 +425,92 @@ func (h *APIHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		switch inMsg.Action {
 		case ActionSubscribe:
-			go client.Subscribe(r.Context(), channel, inMsg.Service)
+			if !client.Supports("subscribe/" + inMsg.Service) {
+				handler.sendResponse(&ClientResponse{
+					Id:      inMsg.Id,
+					Status:  "error",
+					Service: inMsg.Service,
+					Message: "Node does not support subscribing to " + inMsg.Service,
+				})
+				continue
+			}
+
+			if _, ok := subscriptions[inMsg.Service]; !ok {
+				subscriptions[inMsg.Service] = subscribe(client, handler, inMsg.Service)
+			}
 		case ActionUnsubscribe:
-			client.Unsubscribe(r.Context(), channel, inMsg.Service)
+			if subscription, ok := subscriptions[inMsg.Service]; ok {
+				subscription.Unsubscribe()
+				delete(subscriptions, inMsg.Service)
+			}
+		case ActionStart:
+			if err := h.startJob(r.Context(), jobs, handler, client, inMsg.Service, inMsg.Params); err != nil {
+				handler.sendResponse(&ClientResponse{
+					Id:      inMsg.Id,
+					Status:  "error",
+					Service: inMsg.Service,
+					Message: err.Error(),
+				})
+			}
+		case ActionRequest:
+			if err := h.startRequest(r.Context(), requests, handler, client, inMsg.Id, inMsg.Method, inMsg.Params); err != nil {
+				handler.sendResponse(&ClientResponse{
+					Id:      inMsg.Id,
+					Status:  "error",
+					Code:    api_internal.StatusCode(err),
+					Message: err.Error(),
+				})
+			}
+		case ActionCancel:
+			// a request is cancelled by its id, a job by its service
+			if inMsg.Service == "" && inMsg.Id != "" {
+				if !requests.cancel(inMsg.Id) {
+					handler.sendResponse(&ClientResponse{
+						Id:      inMsg.Id,
+						Status:  "error",
+						Message: "No request " + inMsg.Id + " is in progress",
+					})
+				}
+			} else if !jobs.cancel(inMsg.Service) {
+				handler.sendResponse(&ClientResponse{
+					Id:      inMsg.Id,
+					Status:  "error",
+					Service: inMsg.Service,
+					Message: "No " + inMsg.Service + " job is running",
+				})
+			}
 		default:
 			handler.sendResponse(&ClientResponse{
+				Id:      inMsg.Id,
 				Status:  "error",
 				Message: "Unknown action " + inMsg.Action,
 			})
 		}
 	}
 }
+
+// subscribe forwards the messages of the node's service to the client until unsubscribed. The
+// messages the hub drops while the client is slow are accounted for by the overflow policy
+func subscribe(client erigon_node.Client, handler *WebsocketHandler, service string) *erigon_node.Subscription {
+	channel := make(chan []byte, wsSubscriptionBuffer)
+	subscription := client.Subscriptions().Subscribe(service, channel)
+
+	go func() {
+		for {
+			select {
+			case message := <-channel:
+				handler.sendAfterLost(&ClientResponse{
+					Status:  "success",
+					Service: service,
+					Message: string(message),
+				}, subscription.TakeDropped())
+			case <-subscription.Done():
+				return
+			case <-handler.closeChan:
+				return
+			}
+		}
+	}()
+
+	return subscription
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testWebsocketHandler(overflow string) *WebsocketHandler {
+	handler := &WebsocketHandler{overflow: overflow}
+	handler.queueCond = sync.NewCond(&handler.queueLock)
+	return handler
+}
+
+func TestWebsocketHandlerLostMessages(t *testing.T) {
+	for _, tt := range []struct {
+		overflow string
+		want     []ClientResponse // Seq, Status, Dropped and Coalesced of the responses written
+	}{
+		{
+			overflow: OverflowDropOldest,
+			want: []ClientResponse{
+				{Seq: 1, Status: "success"},
+				{Status: StatusGap, Dropped: 3},
+				{Seq: 5, Status: "success"},
+				{Seq: 6, Status: "error"},
+			},
+		},
+		{
+			overflow: OverflowBlock,
+			want: []ClientResponse{
+				{Seq: 1, Status: "success"},
+				{Status: StatusGap, Dropped: 3},
+				{Seq: 5, Status: "success"},
+				{Seq: 6, Status: "error"},
+			},
+		},
+		{
+			// the lost values are superseded by the latest, which replaces the queued value
+			overflow: OverflowCoalesce,
+			want: []ClientResponse{
+				{Seq: 5, Status: "success", Coalesced: 4},
+				{Seq: 6, Status: "error"},
+			},
+		},
+	} {
+		t.Run(tt.overflow, func(t *testing.T) {
+			handler := testWebsocketHandler(tt.overflow)
+
+			handler.sendResponse(&ClientResponse{Status: "success", Service: "s"})
+			handler.sendAfterLost(&ClientResponse{Status: "success", Service: "s"}, 3)
+			handler.sendResponse(&ClientResponse{Status: "error", Service: "s"})
+
+			for i, want := range tt.want {
+				got, ok := handler.nextResponse()
+
+				if !ok {
+					t.Fatalf("response %d: handler stopped", i)
+				}
+
+				if got.Seq != want.Seq || got.Status != want.Status || got.Dropped != want.Dropped || got.Coalesced != want.Coalesced {
+					t.Fatalf("response %d is %+v, want %+v", i, *got, want)
+				}
+			}
+
+			handler.queueLock.Lock()
+			defer handler.queueLock.Unlock()
+
+			if len(handler.queue) != 0 {
+				t.Fatalf("left %d queued", len(handler.queue))
+			}
+		})
+	}
+}
+
+// queued takes the messages queued by the handler without waiting for more
+func queued(handler *WebsocketHandler) []*ClientResponse {
+	var responses []*ClientResponse
+
+	for {
+		handler.queueLock.Lock()
+		empty := len(handler.queue) == 0
+		handler.queueLock.Unlock()
+
+		if empty {
+			return responses
+		}
+
+		response, _ := handler.nextResponse()
+		responses = append(responses, response)
+	}
+}
+
+func TestWebsocketHandlerOverflow(t *testing.T) {
+	const (
+		senders  = 4
+		messages = wsWriteQueueSize // by each sender, overflowing the queue
+		lost     = 2                // before every tenth message
+	)
+
+	for _, overflow := range []string{OverflowDropOldest, OverflowBlock, OverflowCoalesce} {
+		t.Run(overflow, func(t *testing.T) {
+			handler := testWebsocketHandler(overflow)
+
+			var written []*ClientResponse
+			writerDone := make(chan struct{})
+
+			if overflow == OverflowBlock {
+				// the senders are held up until the writer makes room
+				go func() {
+					defer close(writerDone)
+
+					// starts once the queue is full, so senders wait together to be let in
+					for full := false; !full; time.Sleep(time.Millisecond) {
+						handler.queueLock.Lock()
+						full = len(handler.queue) >= wsWriteQueueSize-1
+						handler.queueLock.Unlock()
+					}
+
+					time.Sleep(10 * time.Millisecond)
+
+					for sent := 0; sent < senders*messages; {
+						response, ok := handler.nextResponse()
+
+						if !ok {
+							return
+						}
+
+						handler.queueLock.Lock()
+						if len(handler.queue) > wsWriteQueueSize {
+							t.Errorf("%d messages are queued", len(handler.queue))
+						}
+						handler.queueLock.Unlock()
+
+						if response.Status != StatusGap {
+							sent++
+						}
+
+						written = append(written, response)
+					}
+				}()
+			}
+
+			var wg sync.WaitGroup
+
+			for s := 0; s < senders; s++ {
+				wg.Add(1)
+
+				go func(service string) {
+					defer wg.Done()
+
+					for i := 0; i < messages; i++ {
+						status := "success"
+
+						if i%3 == 0 {
+							status = "error" // never coalesced
+						}
+
+						if i%10 == 9 {
+							handler.sendAfterLost(&ClientResponse{Status: status, Service: service}, lost)
+						} else {
+							handler.sendResponse(&ClientResponse{Status: status, Service: service})
+						}
+					}
+				}(fmt.Sprint("service", s))
+			}
+
+			wg.Wait()
+
+			if overflow == OverflowBlock {
+				<-writerDone
+			} else {
+				written = queued(handler)
+			}
+
+			var prev, gapped, dropped, coalesced, delivered uint64
+
+			for i, response := range written {
+				if response.Status == StatusGap {
+					if i == len(written)-1 || written[i+1].Status == StatusGap {
+						t.Fatalf("gap message %d is not followed by a message", i)
+					}
+
+					gapped += response.Dropped
+					dropped += response.Dropped
+					continue
+				}
+
+				if response.Seq <= prev {
+					t.Fatalf("message %d is numbered %d after %d", i, response.Seq, prev)
+				}
+
+				// coalescing takes queued messages from anywhere before the response, so only
+				// the gaps of the other policies report exactly the messages numbered between
+				if overflow != OverflowCoalesce && response.Seq-prev-1 != gapped {
+					t.Fatalf("message %d is numbered %d after %d, with %d reported dropped before it", i, response.Seq, prev, gapped)
+				}
+
+				prev = response.Seq
+				gapped = 0
+				coalesced += response.Coalesced
+				delivered++
+			}
+
+			total := uint64(senders * (messages + messages/10*lost))
+
+			if prev != total || delivered+dropped+coalesced != total {
+				t.Fatalf("last message is numbered %d of %d, %d delivered, %d dropped and %d coalesced", prev, total, delivered, dropped, coalesced)
+			}
+
+			switch overflow {
+			case OverflowBlock:
+				if delivered != senders*messages {
+					t.Fatalf("%d of %d messages delivered", delivered, senders*messages)
+				}
+			case OverflowDropOldest:
+				if len(written) > wsWriteQueueSize || dropped <= senders*messages/10*lost {
+					t.Fatalf("%d messages queued with %d dropped", len(written), dropped)
+				}
+			case OverflowCoalesce:
+				if len(written) > wsWriteQueueSize || coalesced == 0 {
+					t.Fatalf("%d messages queued with %d coalesced", len(written), coalesced)
+				}
+			}
+		})
+	}
+}
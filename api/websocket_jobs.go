@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+)
+
+const (
+	ServiceReorgs = "reorgs"
+
+	StatusProgress  = "progress"
+	StatusCancelled = "cancelled"
+
+	// jobProgressInterval limits how often a job reports its progress to the client
+	jobProgressInterval = 500 * time.Millisecond
+)
+
+// wsJobs tracks the long-running jobs started over a WebSocket connection, at most one per service,
+// or the requests in progress, at most one per id
+type wsJobs struct {
+	mu   sync.Mutex
+	jobs map[string]context.CancelFunc
+}
+
+func newWsJobs() *wsJobs {
+	return &wsJobs{jobs: map[string]context.CancelFunc{}}
+}
+
+// start runs job on its own goroutine with a context which is cancelled by cancel or cancelAll
+func (j *wsJobs) start(ctx context.Context, service string, job func(ctx context.Context)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.jobs[service]; ok {
+		return fmt.Errorf("%s is already running", service)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j.jobs[service] = cancel
+
+	go func() {
+		defer func() {
+			j.mu.Lock()
+			delete(j.jobs, service)
+			j.mu.Unlock()
+			cancel()
+		}()
+
+		job(ctx)
+	}()
+
+	return nil
+}
+
+func (j *wsJobs) cancel(service string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	cancel, ok := j.jobs[service]
+
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+func (j *wsJobs) cancelAll() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, cancel := range j.jobs {
+		cancel()
+	}
+}
+
+// startJob starts the long-running job for the given service, reporting
+// its progress and result back over the WebSocket
+func (h *APIHandler) startJob(ctx context.Context, jobs *wsJobs, handler *WebsocketHandler, client erigon_node.Client, service string, params map[string]string) error {
+	switch service {
+	case ServiceReorgs:
+		var blocks uint64
+
+		if blocksStr := params["blocks"]; blocksStr != "" {
+			var err error
+
+			if blocks, err = strconv.ParseUint(blocksStr, 10, 64); err != nil {
+				return fmt.Errorf("blocks %s is not a Uint64 number: %w", blocksStr, err)
+			}
+		}
+
+		return jobs.start(ctx, service, func(ctx context.Context) {
+			var lastProgress time.Time
+
+			reorgs, err := client.ScanReorgs(ctx, blocks, func(progress erigon_node.ReorgProgress) {
+				if time.Since(lastProgress) < jobProgressInterval {
+					return
+				}
+
+				lastProgress = time.Now()
+				sendJobResponse(handler, service, StatusProgress, progress)
+			})
+
+			switch {
+			case errors.Is(err, context.Canceled):
+				sendJobResponse(handler, service, StatusCancelled, nil)
+			case err != nil:
+				handler.sendResponse(&ClientResponse{
+					Status:  "error",
+					Service: service,
+					Message: err.Error(),
+				})
+			default:
+				sendJobResponse(handler, service, "success", reorgs)
+			}
+		})
+	default:
+		return fmt.Errorf("unknown job %s", service)
+	}
+}
+
+func sendJobResponse(handler *WebsocketHandler, service string, status string, data interface{}) {
+	response := &ClientResponse{
+		Status:  status,
+		Service: service,
+	}
+
+	if data != nil {
+		message, err := json.Marshal(data)
+
+		if err != nil {
+			response.Status = "error"
+			response.Message = fmt.Sprintf("Unable to encode %s job result: %v", service, err)
+		} else {
+			response.Message = string(message)
+		}
+	}
+
+	handler.sendResponse(response)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+)
+
+// scanningClient scans for reorgs until it is cancelled
+type scanningClient struct {
+	erigon_node.Client
+	scanning chan struct{}
+}
+
+func (c *scanningClient) ScanReorgs(ctx context.Context, blocks uint64, progress erigon_node.ReorgProgressFunc) (erigon_node.Reorg, error) {
+	close(c.scanning)
+	<-ctx.Done()
+	return erigon_node.Reorg{}, ctx.Err()
+}
+
+// running reports whether a job of the service is running
+func (j *wsJobs) running(service string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, ok := j.jobs[service]
+	return ok
+}
+
+func waitStopped(t *testing.T, jobs *wsJobs, service string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if !jobs.running(service) {
+			return
+		}
+	}
+
+	t.Fatalf("%s job is still running", service)
+}
+
+func TestWsJobsCancel(t *testing.T) {
+	jobs := newWsJobs()
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+
+	err := jobs.start(context.Background(), "job", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	if err := jobs.start(context.Background(), "job", func(ctx context.Context) {}); err == nil {
+		t.Fatal("started a job already running")
+	}
+
+	if jobs.cancel("other") || jobs.cancel("") {
+		t.Fatal("cancelled a job which is not running")
+	}
+
+	if !jobs.cancel("job") {
+		t.Fatal("running job was not cancelled")
+	}
+
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Fatalf("job stopped with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled job did not stop")
+	}
+
+	waitStopped(t, jobs, "job")
+
+	if jobs.cancel("job") {
+		t.Fatal("cancelled a job which has stopped")
+	}
+
+	// the service is free to run again
+	if err := jobs.start(context.Background(), "job", func(ctx context.Context) {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartJobCancel(t *testing.T) {
+	h := &APIHandler{}
+	jobs := newWsJobs()
+	handler := testWebsocketHandler(OverflowBlock)
+	client := &scanningClient{scanning: make(chan struct{})}
+
+	if err := h.startJob(context.Background(), jobs, handler, client, "unknown", nil); err == nil {
+		t.Fatal("started an unknown job")
+	}
+
+	if err := h.startJob(context.Background(), jobs, handler, client, ServiceReorgs, map[string]string{"blocks": "x"}); err == nil {
+		t.Fatal("started a job with malformed blocks")
+	}
+
+	if err := h.startJob(context.Background(), jobs, handler, client, ServiceReorgs, map[string]string{"blocks": "10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-client.scanning
+
+	if !jobs.cancel(ServiceReorgs) {
+		t.Fatal("running job was not cancelled")
+	}
+
+	response, ok := handler.nextResponse()
+
+	if !ok || response.Status != StatusCancelled || response.Service != ServiceReorgs {
+		t.Fatalf("cancelled job sent %+v", response)
+	}
+
+	waitStopped(t, jobs, ServiceReorgs)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/erigontech/diagnostics"
+	api_internal "github.com/erigontech/diagnostics/api/internal"
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+)
+
+// startRequest answers a request made over the WebSocket in the background, with a response carrying
+// the request's id. The method is the path of the equivalent HTTP request below the node, such as
+// "sync-stages" or "dbs/chaindata/tables/Headers", and params are its query parameters
+func (h *APIHandler) startRequest(ctx context.Context, requests *wsJobs, handler *WebsocketHandler, client erigon_node.Client, id string, method string, params map[string]string) error {
+	if id == "" {
+		return diagnostics.AsBadRequestErr(fmt.Errorf("request for %s has no id", method))
+	}
+
+	return requests.start(ctx, id, func(ctx context.Context) {
+		result, err := wsRequest(ctx, client, method, params)
+
+		switch {
+		case errors.Is(err, context.Canceled):
+			handler.sendResponse(&ClientResponse{Status: StatusCancelled, Id: id})
+		case err != nil:
+			handler.sendResponse(&ClientResponse{
+				Status:  "error",
+				Id:      id,
+				Code:    api_internal.StatusCode(err),
+				Message: err.Error(),
+			})
+		default:
+			handler.sendResponse(&ClientResponse{Status: "success", Id: id, Result: result})
+		}
+	})
+}
+
+// wsRequest makes the request with the typed client call of its method, and otherwise as a
+// request to the node's universal endpoint
+func wsRequest(ctx context.Context, client erigon_node.Client, method string, params map[string]string) (json.RawMessage, error) {
+	values := url.Values{}
+
+	for param, value := range params {
+		values.Set(param, value)
+	}
+
+	var result interface{}
+	var err error
+
+	switch segments := strings.Split(strings.Trim(method, "/"), "/"); {
+	case nodeDataMethods[method] != nil:
+		result, err = nodeDataMethods[method](ctx, client, values)
+	case method == "sync-stages":
+		result, err = client.FindSyncStages(ctx)
+	case method == "bodies/download-summary":
+		result, err = client.BodiesDownload(ctx)
+	case method == "headers/download-summary":
+		result, err = client.HeadersDownload(ctx)
+	case method == "reorgs":
+		var blocks uint64
+
+		if blocksStr := values.Get("blocks"); blocksStr != "" {
+			if blocks, err = strconv.ParseUint(blocksStr, 10, 64); err != nil {
+				return nil, diagnostics.AsBadRequestErr(fmt.Errorf("blocks %s is not a Uint64 number: %w", blocksStr, err))
+			}
+		}
+
+		result, err = client.ScanReorgs(ctx, blocks, nil)
+	case len(segments) == 3 && segments[0] == "dbs" && segments[2] == "tables":
+		result, err = client.Tables(ctx, segments[1])
+	case len(segments) == 4 && segments[0] == "dbs" && segments[2] == "tables":
+		query, encoding, encode, err := parseTableQuery(values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results, err := client.Table(ctx, segments[1], segments[3], query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = newTableResponse(segments[1], segments[3], encoding, encode, results)
+	default:
+		if len(values) > 0 {
+			method += "?" + values.Encode()
+		}
+
+		pprof, data, err := GetResponseData(ctx, client, method)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !pprof {
+			return data, nil
+		}
+
+		// profiles are base64 encoded, as from the HTTP API
+		result = data
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(result)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -14,9 +15,25 @@ var (
 	listenAddr      string
 	listenPort      int
 	routerPort      int
+	bridgeAddr      string
+	bridgePort      int
+	bridgeTLSCert   string
+	bridgeTLSKey    string
 	insecure        bool
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsSelfSigned   bool
+	bridgeClientCA  string
 	maxNodeSessions int
 	maxUISessions   int
+	nodeSessionTTL  time.Duration
+	bridgeResume    time.Duration
+	uiSessionTTL    time.Duration
+	uiSessionLife   time.Duration
+	sessionStore    string
+	sessionSecret   string
+	sessionTokenTTL time.Duration
+	sessionStoreDb  string
 	logDirPath      string //path of directory to save log file
 	logFileName     string //name of log file with format
 	logFileSizeMax  int    //maximum file size for 1 log file
@@ -41,9 +58,26 @@ func init() {
 	rootCmd.Flags().StringVar(&listenAddr, "addr", "localhost", "network interface to listen on")
 	rootCmd.Flags().IntVar(&listenPort, "port", 8080, "port to listen on")
 	rootCmd.Flags().IntVar(&routerPort, "rest.port", 0, "port to listen on")
+	rootCmd.Flags().MarkDeprecated("rest.port", "it is ignored, use --bridge.port to serve the bridge on its own port")
+	rootCmd.Flags().StringVar(&bridgeAddr, "bridge.addr", "0.0.0.0", "network interface to listen on for nodes when the bridge has its own port")
+	rootCmd.Flags().IntVar(&bridgePort, "bridge.port", 0, "port to serve the bridge for nodes on, separately from the UI and API (default is to serve it on --port)")
+	rootCmd.Flags().StringVar(&bridgeTLSCert, "bridge.tls.cert", "", "certificate file to serve TLS with on the bridge port (default is --tls.cert)")
+	rootCmd.Flags().StringVar(&bridgeTLSKey, "bridge.tls.key", "", "key file of the bridge TLS certificate (default is --tls.key)")
+	rootCmd.Flags().StringVar(&tlsCertFile, "tls.cert", "", "certificate file to serve TLS with, reloaded when it changes")
+	rootCmd.Flags().StringVar(&tlsKeyFile, "tls.key", "", "key file of the TLS certificate")
+	rootCmd.Flags().BoolVar(&tlsSelfSigned, "tls.self.signed", false, "serve TLS with a generated self-signed certificate when no certificate file is given, for local use")
+	rootCmd.Flags().StringVar(&bridgeClientCA, "bridge.client.ca", "", "CA file to verify client certificates against, nodes must present one to connect to the bridge")
 	rootCmd.Flags().BoolVar(&insecure, "insecure", false, "whether to use insecure PIN generation for testing purposes (default is false)")
 	rootCmd.Flags().IntVar(&maxNodeSessions, "node.sessions", 5000, "maximum number of node sessions to allow")
-	rootCmd.Flags().IntVar(&maxUISessions, "ui.sessions", 5000, "maximum number of UI sessions to allow")
+	rootCmd.Flags().IntVar(&maxUISessions, "ui.sessions", 5000, "maximum number of UI sessions to allow, new sessions are refused once there are this many live ones")
+	rootCmd.Flags().DurationVar(&nodeSessionTTL, "node.sessions.ttl", time.Hour, "how long to keep the session of a disconnected node, 0 keeps it until evicted by newer sessions")
+	rootCmd.Flags().DurationVar(&bridgeResume, "bridge.resume.grace", time.Minute, "how long a node can take to reconnect and resume its lost bridge connection, keeping its requests, 0 disables resumption")
+	rootCmd.Flags().DurationVar(&uiSessionTTL, "ui.sessions.ttl", 24*time.Hour, "how long to keep an idle UI session, 0 keeps it until it is revoked")
+	rootCmd.Flags().DurationVar(&uiSessionLife, "ui.sessions.lifetime", 7*24*time.Hour, "how long an issued UI session lasts, however much it is used, 0 lasts until it expires from being idle")
+	rootCmd.Flags().StringVar(&sessionStore, "sessions.store", "memory", "where to keep sessions: memory, or bolt to keep them across restarts")
+	rootCmd.Flags().StringVar(&sessionStoreDb, "sessions.store.path", "./sessions.db", "path of the database file of the bolt session store")
+	rootCmd.Flags().StringVar(&sessionSecret, "sessions.secret", "", "secret to sign session tokens with (default is a random secret, so tokens do not survive a restart)")
+	rootCmd.Flags().DurationVar(&sessionTokenTTL, "sessions.token.ttl", 12*time.Hour, "how long a session token is valid for")
 	rootCmd.Flags().StringVar(&logDirPath, "log.dir.path", "./logs", "directory path to store logs data")
 	rootCmd.Flags().StringVar(&logFileName, "log.file.name", "diagnostics.log", "directory path to store logs data")
 	rootCmd.Flags().IntVar(&logFileSizeMax, "log.file.size.max", 100, "maximum size of log file in mega bytes to allow")
@@ -16,6 +16,7 @@ import (
 	"github.com/erigontech/diagnostics/api"
 	"github.com/erigontech/diagnostics/internal/logging"
 	"github.com/erigontech/diagnostics/internal/sessions"
+	"github.com/erigontech/diagnostics/internal/tlsconfig"
 )
 
 func main() {
@@ -33,51 +34,169 @@ func main() {
 	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT)
 
 	// Initialize Services
-	cache, err := sessions.NewCache(5, 5)
+	var cache sessions.CacheService
+	var err error
+
+	cacheOptions := []sessions.CacheOption{
+		sessions.WithNodeSessionTTL(nodeSessionTTL),
+		sessions.WithBridgeResumeGrace(bridgeResume),
+		sessions.WithUISessionTTL(uiSessionTTL),
+		sessions.WithUISessionLifetime(uiSessionLife),
+	}
+
+	if insecure {
+		cacheOptions = append(cacheOptions, sessions.WithInsecurePins())
+	}
+
+	switch sessionStore {
+	case "memory":
+		cache, err = sessions.NewCache(maxNodeSessions, maxUISessions, cacheOptions...)
+	case "bolt":
+		cache, err = sessions.NewBoltCache(sessionStoreDb, maxNodeSessions, maxUISessions, cacheOptions...)
+	default:
+		err = fmt.Errorf("unknown session store %q: expected memory or bolt", sessionStore)
+	}
 
 	if err != nil {
 		log.Fatalf("session cache creation  failed: %v", err)
 	}
 
+	defer cache.Close()
+
+	tokens, err := sessions.NewTokenSigner([]byte(sessionSecret), sessionTokenTTL)
+
+	if err != nil {
+		log.Fatalf("session token signer creation failed: %v", err)
+	}
+
+	// the bridge is served with the UI unless it has its own listener
+	separateBridge := bridgePort != 0
+
+	tlsOptions := tlsconfig.Options{
+		CertFile:   tlsCertFile,
+		KeyFile:    tlsKeyFile,
+		SelfSigned: tlsSelfSigned,
+		Hosts:      []string{listenAddr},
+	}
+
+	if !separateBridge {
+		tlsOptions.ClientCAFile = bridgeClientCA
+	}
+
+	tlsConfig, err := tlsconfig.New(tlsOptions)
+
+	if err != nil {
+		log.Fatalf("TLS configuration failed: %v", err)
+	}
+
+	services := api.APIServices{
+		StoreSession:     cache,
+		SessionTokens:    tokens,
+		BridgeClientCert: bridgeClientCA != "",
+		SeparateBridge:   separateBridge,
+	}
+
 	// Passing in the services to REST layer
-	handlers := api.NewHandler(
-		api.APIServices{
-			StoreSession: cache,
-		})
+	handlers := api.NewHandler(services)
 
 	srv := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", listenAddr, listenPort),
 		Handler:           handlers,
+		TLSConfig:         tlsConfig,
 		MaxHeaderBytes:    1 << 20,
 		ReadHeaderTimeout: 1 * time.Minute,
 	}
 
-	go func() {
-		err := srv.ListenAndServe()
+	serve(srv)
+
+	servers := []*http.Server{srv}
+
+	if separateBridge {
+		bridgeTLSConfig, err := tlsconfig.New(tlsconfig.Options{
+			CertFile:          firstNonEmpty(bridgeTLSCert, tlsCertFile),
+			KeyFile:           firstNonEmpty(bridgeTLSKey, tlsKeyFile),
+			SelfSigned:        tlsSelfSigned,
+			Hosts:             []string{bridgeAddr},
+			ClientCAFile:      bridgeClientCA,
+			RequireClientCert: true,
+		})
 
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("bridge TLS configuration failed: %v", err)
 		}
-	}()
+
+		// nodes only need to send the bridge request before switching to a websocket,
+		// which is long lived so the server does not time out reads and writes
+		bridgeSrv := &http.Server{
+			Addr:              fmt.Sprintf("%s:%d", bridgeAddr, bridgePort),
+			Handler:           api.NewBridgeServerHandler(services),
+			TLSConfig:         bridgeTLSConfig,
+			MaxHeaderBytes:    1 << 16,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       time.Minute,
+		}
+
+		serve(bridgeSrv)
+		servers = append(servers, bridgeSrv)
+
+		fmt.Printf("Diagnostics bridge is listening on %s:%d\n", bridgeAddr, bridgePort)
+	}
 
 	printUIVersion()
 
-	fmt.Printf("Diagnostics UI is running on http://%s:%d\n", listenAddr, listenPort)
+	scheme := "http"
+
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	fmt.Printf("Diagnostics UI is running on %s://%s:%d\n", scheme, listenAddr, listenPort)
 	//open(fmt.Sprintf("http://%s:%d", listenAddr, listenPort))
 
 	// Graceful and eager terminations
 	switch s := <-signalCh; s {
 	case syscall.SIGTERM:
 		log.Println("Terminating gracefully.")
-		if err := srv.Shutdown(context.Background()); !errors.Is(err, http.ErrServerClosed) {
-			log.Println("Failed to shutdown server:", err)
+		for _, srv := range servers {
+			if err := srv.Shutdown(context.Background()); !errors.Is(err, http.ErrServerClosed) {
+				log.Println("Failed to shutdown server:", err)
+			}
 		}
 	case syscall.SIGINT:
 		log.Println("Terminating eagerly.")
+		cache.Close()
 		os.Exit(-int(syscall.SIGINT))
 	}
 }
 
+// serve starts the server in the background, with TLS when it has a TLS config
+func serve(srv *http.Server) {
+	go func() {
+		var err error
+
+		if srv.TLSConfig != nil {
+			// the certificate comes from the TLS config
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
 func printUIVersion() {
 	packagePath := "github.com/erigontech/erigonwatch"
 	version, err := GetPackageVersion(packagePath)
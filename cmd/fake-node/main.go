@@ -0,0 +1,122 @@
+// fake-node connects to diagnostics as an Erigon node would and answers its requests
+// from fixture data, for developing and trying out the server and UI without a node
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/erigontech/diagnostics/internal/fakenode"
+)
+
+var (
+	bridgeURL            string
+	sessionPin           string
+	sessionSecret        string
+	nodeId               string
+	nodeName             string
+	protocolVersion      uint64
+	fixturesDir          string
+	chunkSize            int
+	subscriptionInterval time.Duration
+	tlsCertFile          string
+	tlsKeyFile           string
+	tlsInsecure          bool
+	verbose              bool
+
+	rootCmd = &cobra.Command{
+		Use:   "fake-node",
+		Short: "Fake Erigon node for diagnostics development",
+		Long:  `Connects to the diagnostics bridge as an Erigon node and answers its requests from fixture data`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run()
+		},
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	rootCmd.Flags().StringVar(&bridgeURL, "url", "ws://localhost:8080/bridge", "URL of the diagnostics bridge")
+	rootCmd.Flags().StringVar(&sessionPin, "session", "", "PIN of the session to attach to")
+	rootCmd.Flags().StringVar(&sessionSecret, "secret", "", "bridge secret of the session, in hex as shown with the session")
+	rootCmd.Flags().StringVar(&nodeId, "node.id", "fake-node", "id of the node")
+	rootCmd.Flags().StringVar(&nodeName, "node.name", "erigon/fake-node", "name of the node")
+	rootCmd.Flags().Uint64Var(&protocolVersion, "protocol.version", 0, "newest bridge protocol version to speak (default is the newest supported)")
+	rootCmd.Flags().StringVar(&fixturesDir, "fixtures", "", "directory to read fixtures from (default is the built in fixtures)")
+	rootCmd.Flags().IntVar(&chunkSize, "chunk.size", 64*1024, "size in bytes of log chunks and of the binary frames results are split into")
+	rootCmd.Flags().DurationVar(&subscriptionInterval, "subscription.interval", time.Second, "time between the messages sent to subscribers")
+	rootCmd.Flags().StringVar(&tlsCertFile, "tls.cert", "", "client certificate file to present to the bridge")
+	rootCmd.Flags().StringVar(&tlsKeyFile, "tls.key", "", "key file of the client certificate")
+	rootCmd.Flags().BoolVar(&tlsInsecure, "tls.insecure", false, "skip verifying the bridge's certificate, such as a self-signed one")
+	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "log every request")
+
+	rootCmd.MarkFlagRequired("session")
+	rootCmd.MarkFlagRequired("secret")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	secret, err := hex.DecodeString(sessionSecret)
+
+	if err != nil {
+		return fmt.Errorf("invalid session secret: %w", err)
+	}
+
+	var fixtures *fakenode.Fixtures
+
+	if fixturesDir != "" {
+		fixtures, err = fakenode.LoadFixtures(os.DirFS(fixturesDir))
+	} else {
+		fixtures, err = fakenode.DefaultFixtures()
+	}
+
+	if err != nil {
+		return fmt.Errorf("loading fixtures: %w", err)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: tlsInsecure} //nolint:gosec // opted into for self-signed bridges
+
+	if tlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	node := fakenode.New(fakenode.Config{
+		URL:                  bridgeURL,
+		Sessions:             map[string][]byte{sessionPin: secret},
+		NodeId:               nodeId,
+		NodeName:             nodeName,
+		Version:              protocolVersion,
+		TLSConfig:            tlsConfig,
+		Fixtures:             fixtures,
+		ChunkSize:            chunkSize,
+		SubscriptionInterval: subscriptionInterval,
+		Verbose:              verbose,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	log.Printf("Connecting to %s as node %s\n", bridgeURL, nodeId)
+
+	return node.Run(ctx)
+}
@@ -14,6 +14,7 @@ require (
 require (
 	github.com/go-chi/cors v1.2.1
 	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.3.10
 )
 
 require (
@@ -0,0 +1,40 @@
+package erigon_node
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnsupportedMethod is returned for requests the node has not advertised it supports
+var ErrUnsupportedMethod = errors.New("method not supported by node")
+
+// SetCapabilities records the methods the node supports, nil when it did not advertise them,
+// in which case every method is tried. A capability matches the method with that name and
+// the methods below it, so "dbs" or "dbs/*" matches "dbs/chaindata/tables", "*" matches all
+func (c *NodeClient) SetCapabilities(capabilities []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.capabilities = capabilities
+}
+
+// Supports returns true when the method may be requested from the node
+func (c *NodeClient) Supports(method string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.capabilities == nil {
+		return true
+	}
+
+	method, _, _ = strings.Cut(method, "?")
+
+	for _, capability := range c.capabilities {
+		capability = strings.TrimSuffix(capability, "/*")
+
+		if capability == "*" || method == capability || strings.HasPrefix(method, capability+"/") {
+			return true
+		}
+	}
+
+	return false
+}
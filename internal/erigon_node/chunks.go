@@ -0,0 +1,198 @@
+package erigon_node
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ChunkHeader starts each binary frame a node sends a result in, when the result is
+// too large for one message or is raw bytes which would be inflated by base64 in JSON.
+// A binary frame is the 4 byte big endian length of the JSON encoded header, the
+// header, then the bytes of the chunk
+type ChunkHeader struct {
+	Id   string `json:"id"`
+	Seq  uint64 `json:"seq"`            // position of the chunk in the result, from 0
+	More bool   `json:"more,omitempty"` // more chunks of the result follow
+	// Raw chunks are the bytes of the result's content, such as a log chunk or a profile, with the
+	// rest of the result in Result of the first chunk. Otherwise they are the JSON encoded result
+	Raw    bool            `json:"raw,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Last   bool            `json:"last,omitempty"` // as in Response, set on the final chunk
+}
+
+const chunkHeaderLength = 4
+
+var ErrInvalidChunk = errors.New("invalid result chunk")
+
+// EncodeChunk returns the binary frame of the chunk
+func EncodeChunk(header ChunkHeader, data []byte) ([]byte, error) {
+	encoded, err := json.Marshal(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, chunkHeaderLength, chunkHeaderLength+len(encoded)+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(encoded)))
+	frame = append(frame, encoded...)
+	return append(frame, data...), nil
+}
+
+// DecodeChunk returns the header and bytes of a binary frame
+func DecodeChunk(frame []byte) (ChunkHeader, []byte, error) {
+	var header ChunkHeader
+
+	if len(frame) < chunkHeaderLength {
+		return header, nil, fmt.Errorf("%w: frame of %d bytes", ErrInvalidChunk, len(frame))
+	}
+
+	length := binary.BigEndian.Uint32(frame)
+
+	if uint64(len(frame)-chunkHeaderLength) < uint64(length) {
+		return header, nil, fmt.Errorf("%w: header of %d bytes in a frame of %d", ErrInvalidChunk, length, len(frame))
+	}
+
+	if err := json.Unmarshal(frame[chunkHeaderLength:chunkHeaderLength+length], &header); err != nil {
+		return header, nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+
+	return header, frame[chunkHeaderLength+length:], nil
+}
+
+// ChunkFrames splits a response into the binary frames of chunks of at most chunkSize bytes. When raw
+// is given it is sent as raw bytes besides the response's result, otherwise the result is split
+func ChunkFrames(response *Response, raw []byte, chunkSize int) ([][]byte, error) {
+	header := ChunkHeader{Id: response.Id, Raw: raw != nil}
+	data := raw
+
+	if header.Raw {
+		header.Result = response.Result
+	} else {
+		data = response.Result
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = len(data)
+	}
+
+	var frames [][]byte
+
+	for {
+		size := min(chunkSize, len(data))
+		header.More = size < len(data)
+		header.Last = !header.More && response.Last
+
+		frame, err := EncodeChunk(header, data[:size])
+
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame)
+		data = data[size:]
+
+		if !header.More {
+			return frames, nil
+		}
+
+		header.Seq++
+		header.Result = nil
+	}
+}
+
+// ChunkAssembler reassembles the results which nodes send in chunks
+type ChunkAssembler struct {
+	lock    sync.Mutex
+	limit   int // maximum size of a reassembled result
+	total   int // maximum size of all the results being reassembled together
+	pending int // bytes held of the results being reassembled
+	results map[string]*partialResult
+}
+
+type partialResult struct {
+	next   uint64
+	raw    bool
+	result json.RawMessage
+	data   []byte
+}
+
+// NewChunkAssembler returns an assembler of results of up to limit bytes, holding up to
+// total bytes of the results in progress at once, 0 leaves either unlimited
+func NewChunkAssembler(limit int, total int) *ChunkAssembler {
+	return &ChunkAssembler{limit: limit, total: total, results: map[string]*partialResult{}}
+}
+
+// Add adds the chunk to its result, returning the response once the result is complete. A chunk out
+// of sequence, or which takes the result over the size limit or the results in progress over the
+// total limit, fails the result with an error response
+func (a *ChunkAssembler) Add(header ChunkHeader, data []byte) *Response {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	partial, ok := a.results[header.Id]
+
+	if !ok {
+		partial = &partialResult{raw: header.Raw, result: header.Result}
+		a.results[header.Id] = partial
+	}
+
+	var err error
+
+	switch {
+	case header.Seq != partial.next:
+		err = fmt.Errorf("%w: chunk %d of result %s, expected %d", ErrInvalidChunk, header.Seq, header.Id, partial.next)
+	case header.Raw != partial.raw:
+		err = fmt.Errorf("%w: chunk %d of result %s changes its encoding", ErrInvalidChunk, header.Seq, header.Id)
+	case a.limit > 0 && len(partial.data)+len(data) > a.limit:
+		err = fmt.Errorf("%w: result %s is larger than %d bytes", ErrInvalidChunk, header.Id, a.limit)
+	case a.total > 0 && a.pending+len(data) > a.total:
+		err = fmt.Errorf("%w: result %s takes the results in progress over %d bytes", ErrInvalidChunk, header.Id, a.total)
+	}
+
+	if err != nil {
+		a.remove(header.Id)
+		return &Response{Id: header.Id, Error: &Error{Message: err.Error()}, Last: true}
+	}
+
+	partial.next++
+	partial.data = append(partial.data, data...)
+	a.pending += len(data)
+
+	if header.More {
+		return nil
+	}
+
+	a.remove(header.Id)
+
+	response := &Response{Id: header.Id, Last: header.Last}
+
+	if partial.raw {
+		response.Result = partial.result
+		response.Binary = partial.data
+
+		if response.Binary == nil {
+			response.Binary = []byte{}
+		}
+	} else {
+		response.Result = partial.data
+	}
+
+	return response
+}
+
+// Discard drops the chunks received so far of a result nobody waits for
+func (a *ChunkAssembler) Discard(id string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.remove(id)
+}
+
+func (a *ChunkAssembler) remove(id string) {
+	if partial, ok := a.results[id]; ok {
+		a.pending -= len(partial.data)
+		delete(a.results, id)
+	}
+}
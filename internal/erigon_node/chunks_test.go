@@ -0,0 +1,87 @@
+package erigon_node
+
+import (
+	"bytes"
+	"testing"
+)
+
+func addFrames(t *testing.T, assembler *ChunkAssembler, frames [][]byte) *Response {
+	t.Helper()
+
+	var response *Response
+
+	for i, frame := range frames {
+		header, data, err := DecodeChunk(frame)
+
+		if err != nil {
+			t.Fatalf("DecodeChunk: %v", err)
+		}
+
+		if response != nil {
+			t.Fatalf("response after chunk %d of %d", i, len(frames))
+		}
+
+		response = assembler.Add(header, data)
+	}
+
+	return response
+}
+
+func TestChunkAssembler(t *testing.T) {
+	raw := bytes.Repeat([]byte("0123456789"), 10)
+	frames, err := ChunkFrames(&Response{Id: "1", Result: []byte(`{"name":"log"}`), Last: true}, raw, 16)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := addFrames(t, NewChunkAssembler(0, 0), frames)
+
+	if response == nil || response.Error != nil || !response.Last {
+		t.Fatalf("got %+v", response)
+	}
+
+	if string(response.Result) != `{"name":"log"}` || !bytes.Equal(response.Binary, raw) {
+		t.Fatalf("got result %s, %d bytes", response.Result, len(response.Binary))
+	}
+
+	if response := addFrames(t, NewChunkAssembler(len(raw)-1, 0), frames); response == nil || response.Error == nil {
+		t.Fatalf("result over the limit got %+v", response)
+	}
+}
+
+func TestChunkAssemblerTotal(t *testing.T) {
+	assembler := NewChunkAssembler(100, 150)
+
+	add := func(id string, seq uint64, size int) *Response {
+		return assembler.Add(ChunkHeader{Id: id, Seq: seq, More: true}, make([]byte, size))
+	}
+
+	if add("1", 0, 100) != nil || add("2", 0, 40) != nil {
+		t.Fatal("results within the limits failed")
+	}
+
+	// a chunk taking the results in progress over the total limit fails its result
+	if response := add("2", 1, 20); response == nil || response.Error == nil || response.Id != "2" {
+		t.Fatalf("result over the total limit got %+v", response)
+	}
+
+	// which frees its bytes, as do discarded results
+	if add("3", 0, 50) != nil {
+		t.Fatal("bytes of the failed result were not freed")
+	}
+
+	assembler.Discard("1")
+
+	if add("4", 0, 100) != nil {
+		t.Fatal("bytes of the discarded result were not freed")
+	}
+
+	if response := assembler.Add(ChunkHeader{Id: "3", Seq: 1}, nil); response == nil || response.Error != nil {
+		t.Fatalf("completing a result got %+v", response)
+	}
+
+	if add("5", 0, 50) != nil {
+		t.Fatal("bytes of the completed result were not freed")
+	}
+}
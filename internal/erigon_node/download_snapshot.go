@@ -0,0 +1,176 @@
+package erigon_node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// downloadSnapshot is the ordered state of the items (headers or bodies) a node is downloading.
+// The node reports either a full snapshot or the changes since the tick it was last asked for,
+// which are applied here so only the deltas are transferred on each poll
+type downloadSnapshot struct {
+	lock   sync.Mutex
+	method string
+	tick   int64
+	items  []SnapshotItem // ordered by Id
+}
+
+func newDownloadSnapshot(method string) *downloadSnapshot {
+	return &downloadSnapshot{method: method}
+}
+
+// update fetches the changes since the last tick from the node and applies them,
+// then calls view with the resulting state while it is locked
+func (s *downloadSnapshot) update(ctx context.Context, c *NodeClient, view func(tick int64, items []SnapshotItem)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	request, err := c.fetch(ctx, s.method, url.Values{"sinceTick": []string{strconv.FormatInt(s.tick, 10)}})
+
+	if err != nil {
+		return fmt.Errorf("fetching list of changes: %w", err)
+	}
+
+	for {
+		more, result, err := request.nextResult(ctx)
+
+		if err != nil {
+			return fmt.Errorf("fetching list of changes: %w", err)
+		}
+
+		if err := s.apply(resultText(result)); err != nil {
+			// the state can no longer be trusted, start over from a full snapshot
+			s.tick = 0
+			s.items = s.items[:0]
+			return err
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	view(s.tick, s.items)
+
+	return nil
+}
+
+// apply parses the lines of a snapshot or changes report, which start with a
+// "snapshot <tick>" or "changes <tick>" line followed by "<id>,<state>" lines.
+// A zero state removes the item
+func (s *downloadSnapshot) apply(text string) error {
+	var changesMode bool
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case len(line) == 0:
+			// Skip empty lines
+		case strings.HasPrefix(line, "snapshot "):
+			tick, err := strconv.ParseInt(line[len("snapshot "):], 10, 64)
+			if err != nil {
+				return fmt.Errorf("parsing snapshot tick [%s]: %w", line, err)
+			}
+			s.tick = tick
+			s.items = s.items[:0]
+			changesMode = false
+		case strings.HasPrefix(line, "changes "):
+			tick, err := strconv.ParseInt(line[len("changes "):], 10, 64)
+			if err != nil {
+				return fmt.Errorf("parsing changes tick [%s]: %w", line, err)
+			}
+			s.tick = tick
+			changesMode = true
+		default:
+			splits := strings.Split(line, ",")
+			if len(splits) != 2 {
+				return fmt.Errorf("snapshot or change line must have 2 comma-separated items [%s]", line)
+			}
+			id, err := strconv.ParseUint(splits[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("parsing id [%s]: %w", splits[0], err)
+			}
+			state, err := strconv.ParseUint(splits[1], 10, 8)
+			if err != nil {
+				return fmt.Errorf("parsing state [%s]: %w", splits[1], err)
+			}
+			if changesMode {
+				s.tick++
+			}
+			s.set(id, byte(state))
+		}
+	}
+
+	return nil
+}
+
+func (s *downloadSnapshot) set(id uint64, state byte) {
+	i, found := slices.BinarySearchFunc(s.items, id, func(item SnapshotItem, id uint64) int {
+		switch {
+		case item.Id < id:
+			return -1
+		case item.Id > id:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	switch {
+	case state == 0 && found:
+		s.items = slices.Delete(s.items, i, i+1)
+	case state == 0:
+	case found:
+		s.items[i].State = state
+	default:
+		s.items = slices.Insert(s.items, i, SnapshotItem{Id: id, State: state})
+	}
+}
+
+// visibleStates returns a copy of the states of the first VisLimit items from the lowest id,
+// together with the legend of the states in use
+func visibleStates(items []SnapshotItem) (uint64, []SnapshotItem, [9]bool) {
+	var legends [9]bool
+
+	if len(items) == 0 {
+		return 0, []SnapshotItem{}, legends
+	}
+
+	first := items[0].Id
+	states := make([]SnapshotItem, 0, min(len(items), VisLimit))
+
+	for _, item := range items {
+		if item.Id >= first+VisLimit { // We limit visualisation to VisLimit first items
+			break
+		}
+
+		states = append(states, item)
+
+		if int(item.State) < len(legends) {
+			legends[item.State] = true
+		}
+	}
+
+	return first, states, legends
+}
+
+// resultText returns the text of a snapshot or changes report, which the node
+// sends either as a JSON string or as the raw text
+func resultText(result json.RawMessage) string {
+	var text string
+
+	if err := json.Unmarshal(result, &text); err != nil {
+		return string(result)
+	}
+
+	return text
+}
+
+const VisLimit = 1000
@@ -0,0 +1,70 @@
+package erigon_node
+
+import (
+	"context"
+	"fmt"
+)
+
+// DownloaderStatus is the progress of the snapshot downloader, with sizes in bytes and rates in bytes per second
+type DownloaderStatus struct {
+	Downloaded           uint64                     `json:"downloaded"`
+	Total                uint64                     `json:"total"`
+	TotalTime            float64                    `json:"totalTime"` // seconds
+	DownloadRate         uint64                     `json:"downloadRate"`
+	UploadRate           uint64                     `json:"uploadRate"`
+	Peers                int32                      `json:"peers"`
+	Files                int32                      `json:"files"`
+	Connections          uint64                     `json:"connections"`
+	DownloadFinished     bool                       `json:"downloadFinished"`
+	TorrentMetadataReady int32                      `json:"torrentMetadataReady"`
+	Segments             map[string]SegmentDownload `json:"segments"`
+}
+
+// SegmentDownload is the progress of the download of one snapshot segment
+type SegmentDownload struct {
+	Name            string        `json:"name"`
+	TotalBytes      uint64        `json:"totalBytes"`
+	DownloadedBytes uint64        `json:"downloadedBytes"`
+	Webseeds        []SegmentPeer `json:"webseeds"`
+	Peers           []SegmentPeer `json:"peers"`
+}
+
+type SegmentPeer struct {
+	Url          string `json:"url"`
+	DownloadRate uint64 `json:"downloadRate"`
+}
+
+// Validate requires no more to have been downloaded than there is to download
+func (s DownloaderStatus) Validate() error {
+	if s.Total > 0 && s.Downloaded > s.Total {
+		return fmt.Errorf("downloaded %d bytes of %d", s.Downloaded, s.Total)
+	}
+
+	if s.Peers < 0 || s.Files < 0 || s.TorrentMetadataReady < 0 {
+		return fmt.Errorf("negative peer, file or torrent count")
+	}
+
+	for name, segment := range s.Segments {
+		if segment.TotalBytes > 0 && segment.DownloadedBytes > segment.TotalBytes {
+			return fmt.Errorf("downloaded %d bytes of segment %s of %d", segment.DownloadedBytes, name, segment.TotalBytes)
+		}
+	}
+
+	return nil
+}
+
+// snapshotSync holds the downloader status among the node's sync statistics
+type snapshotSync struct {
+	SnapshotDownload DownloaderStatus `json:"snapshotDownload"`
+}
+
+func (s snapshotSync) Validate() error {
+	return s.SnapshotDownload.Validate()
+}
+
+// DownloaderStatus returns the progress of the node's snapshot downloader
+func (c *NodeClient) DownloaderStatus(ctx context.Context) (DownloaderStatus, error) {
+	var sync snapshotSync
+	err := c.fetchData(ctx, "snapshot-sync", &sync)
+	return sync.SnapshotDownload, err
+}
@@ -3,6 +3,7 @@ package erigon_node
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -17,13 +18,25 @@ type NodeClient struct {
 	requestId      uint64
 	requestChannel chan *NodeRequest
 	nodeId         string
+
+	headersSnapshot *downloadSnapshot
+	bodiesSnapshot  *downloadSnapshot
+	capabilities    []string      // methods the node supports, nil when unknown
+	connected       bool          // whether the node has a bridge to send requests over
+	connectWait     chan struct{} // closed when the node connects
+	subscriptions   *SubscriptionHub
 }
 
 func NewClient(nodeId string, requestChannel chan *NodeRequest) Client {
-	return &NodeClient{
-		nodeId:         nodeId,
-		requestChannel: requestChannel,
+	client := &NodeClient{
+		nodeId:          nodeId,
+		requestChannel:  requestChannel,
+		headersSnapshot: newDownloadSnapshot("headers_download"),
+		bodiesSnapshot:  newDownloadSnapshot("block_body_download"),
 	}
+
+	client.subscriptions = newSubscriptionHub(client)
+	return client
 }
 
 func (c *NodeClient) nextRequestId() string {
@@ -39,6 +52,14 @@ func (c *NodeClient) fetch(ctx context.Context, method string, params url.Values
 		return nil, fmt.Errorf("ERROR: Node is not allocated")
 	}
 
+	if !c.Supports(method) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, method)
+	}
+
+	if !c.isConnected() {
+		return nil, ErrNodeDisconnected
+	}
+
 	nodeRequest := &NodeRequest{
 		Responses: make(chan *Response),
 		Request: &Request{
@@ -48,13 +69,66 @@ func (c *NodeClient) fetch(ctx context.Context, method string, params url.Values
 				NodeId:      c.nodeId,
 				QueryParams: params,
 			},
-		}}
+		},
+		ctx: ctx,
+	}
 
-	c.requestChannel <- nodeRequest
+	if deadline, ok := ctx.Deadline(); ok {
+		nodeRequest.Request.Deadline = &deadline
+	}
+
+	select {
+	case c.requestChannel <- nodeRequest:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 
 	return nodeRequest, nil
 }
 
+// SetConnected records whether the node has a bridge, requests fail with
+// ErrNodeDisconnected rather than wait for one while it has not
+func (c *NodeClient) SetConnected(connected bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.connected = connected
+
+	if connected && c.connectWait != nil {
+		close(c.connectWait)
+		c.connectWait = nil
+	}
+}
+
+// waitConnected returns once the node is connected, or ctx is done
+func (c *NodeClient) waitConnected(ctx context.Context) error {
+	c.lock.Lock()
+
+	if c.connected {
+		c.lock.Unlock()
+		return nil
+	}
+
+	if c.connectWait == nil {
+		c.connectWait = make(chan struct{})
+	}
+
+	connectWait := c.connectWait
+	c.lock.Unlock()
+
+	select {
+	case <-connectWait:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *NodeClient) isConnected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.connected
+}
+
 func (c *NodeClient) GetResponse(ctx context.Context, api string) (interface{}, error) {
 	var response interface{}
 
@@ -77,26 +151,80 @@ func (c *NodeClient) GetResponse(ctx context.Context, api string) (interface{},
 	return response, nil
 }
 
+// ErrInvalidNodeData is returned for node data which does not match its type
+var ErrInvalidNodeData = errors.New("invalid node data")
+
+// nodeData is implemented by the types of node data, to check what the node sent
+type nodeData interface {
+	Validate() error
+}
+
+// fetchData requests the method and decodes its result into data, which it validates
+func (c *NodeClient) fetchData(ctx context.Context, method string, data nodeData) error {
+	request, err := c.fetch(ctx, method, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, result, err := request.nextResult(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(result, data); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidNodeData, method, err)
+	}
+
+	if err := data.Validate(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidNodeData, method, err)
+	}
+
+	return nil
+}
+
 func NewErigonNodeClient() Client {
-	return &NodeClient{}
+	client := &NodeClient{
+		headersSnapshot: newDownloadSnapshot("headers_download"),
+		bodiesSnapshot:  newDownloadSnapshot("block_body_download"),
+	}
+
+	client.subscriptions = newSubscriptionHub(client)
+	return client
+}
+
+// Subscriptions returns the hub which shares the node's subscriptions between subscribers
+func (c *NodeClient) Subscriptions() *SubscriptionHub {
+	return c.subscriptions
 }
 
 type Client interface {
 	FindSyncStages(ctx context.Context) (SyncStageProgress, error)
 	Log(ctx context.Context, w http.ResponseWriter, file string, offset int64, size int64, download bool) error
+	LogChunks(ctx context.Context, file string, offset int64, limit int64, chunk func(content LogContent) error) error
 	Tables(ctx context.Context, db string) (Tables, error)
-	Table(ctx context.Context, db string, table string) (Results, error)
-	FindReorgs(ctx context.Context, w http.ResponseWriter) (Reorg, error)
+	Table(ctx context.Context, db string, table string, query TableQuery) (Results, error)
+	FindReorgs(ctx context.Context, w http.ResponseWriter, blocks uint64) (Reorg, error)
+	ScanReorgs(ctx context.Context, blocks uint64, progress ReorgProgressFunc) (Reorg, error)
 	GetResponse(ctx context.Context, api string) (interface{}, error)
+	BodiesDownload(ctx context.Context) (BodyDownload, error)
+	HeadersDownload(ctx context.Context) (HeaderDownload, error)
 
-	// TODO: refactor the following methods to follow above pattern where appropriate
-	BodiesDownload(ctx context.Context, w http.ResponseWriter)
-	HeadersDownload(ctx context.Context, w http.ResponseWriter)
+	Peers(ctx context.Context) (Peers, error)
+	DownloaderStatus(ctx context.Context) (DownloaderStatus, error)
+	SysInfo(ctx context.Context) (SysInfo, error)
+	Flags(ctx context.Context) (Flags, error)
+	CommandLine(ctx context.Context) (CommandLine, error)
+	NodeInfo(ctx context.Context) (NodesInfo, error)
 
 	FindProfile(ctx context.Context, profile string) ([]byte, error)
 
+	SetCapabilities(capabilities []string)
+	SetConnected(connected bool)
+	Supports(method string) bool
+
 	fetch(ctx context.Context, method string, params url.Values) (*NodeRequest, error)
 
-	Subscribe(ctx context.Context, channel chan []byte, service string) error
-	Unsubscribe(ctx context.Context, channel chan []byte, service string) error
+	Subscriptions() *SubscriptionHub
 }
@@ -0,0 +1,52 @@
+package erigon_node
+
+import (
+	"context"
+	"errors"
+)
+
+// Flags are the values of the node's command line flags, by flag name
+type Flags map[string]interface{}
+
+// Validate requires the node to have sent its flags, each with a name
+func (f Flags) Validate() error {
+	if f == nil {
+		return errors.New("no flags")
+	}
+
+	if _, ok := f[""]; ok {
+		return errors.New("flag without a name")
+	}
+
+	return nil
+}
+
+// CommandLine is the command the node was started with, the program then its arguments
+type CommandLine []string
+
+// Validate requires the command line to name its program
+func (c CommandLine) Validate() error {
+	if len(c) == 0 {
+		return errors.New("empty command line")
+	}
+
+	if c[0] == "" {
+		return errors.New("command line without a program")
+	}
+
+	return nil
+}
+
+// Flags returns the values of the node's flags, whether given on the command line or defaulted
+func (c *NodeClient) Flags(ctx context.Context) (Flags, error) {
+	var flags Flags
+	err := c.fetchData(ctx, "flags", &flags)
+	return flags, err
+}
+
+// CommandLine returns the command the node was started with
+func (c *NodeClient) CommandLine(ctx context.Context) (CommandLine, error) {
+	var command CommandLine
+	err := c.fetchData(ctx, "cmdline", &command)
+	return command, err
+}
@@ -2,13 +2,21 @@ package erigon_node
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
 )
 
 func (c *NodeClient) Log(ctx context.Context, w http.ResponseWriter, file string, offset int64, limit int64, download bool) error {
+	return c.LogChunks(ctx, file, offset, limit, func(content LogContent) error {
+		_, err := w.Write(content.Chunk)
+		return err
+	})
+}
+
+// LogChunks reads the log file from offset, passing each chunk the node sends
+// to the chunk func as it arrives
+func (c *NodeClient) LogChunks(ctx context.Context, file string, offset int64, limit int64, chunk func(content LogContent) error) error {
 	var params url.Values
 
 	if offset > 0 || limit > 0 {
@@ -28,7 +36,7 @@ func (c *NodeClient) Log(ctx context.Context, w http.ResponseWriter, file string
 	}
 
 	for {
-		more, result, err := request.nextResult(ctx)
+		response, err := request.nextResponse(ctx)
 
 		if err != nil {
 			return err
@@ -36,15 +44,15 @@ func (c *NodeClient) Log(ctx context.Context, w http.ResponseWriter, file string
 
 		var content LogContent
 
-		if err := json.Unmarshal(result, &content); err != nil {
+		if err := decodeContent(response, &content, &content.Chunk); err != nil {
 			return err
 		}
 
-		if _, err := w.Write(content.Chunk); err != nil {
+		if err := chunk(content); err != nil {
 			return err
 		}
 
-		if !more {
+		if response.Last {
 			break
 		}
 	}
@@ -0,0 +1,164 @@
+package erigon_node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNodeDataValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		data  nodeData
+		valid bool
+	}{
+		{"peers", Peers{{Id: "a", Type: "sentry"}, {Id: "b", Type: "Sentinel"}, {Id: "c"}}, true},
+		{"no peers", Peers{}, true},
+		{"peer without id", Peers{{Id: "a"}, {Type: "sentry"}}, false},
+		{"peer listed twice", Peers{{Id: "a", Type: "sentry"}, {Id: "a", Type: "sentry"}}, false},
+		{"peer listed twice with other types", Peers{{Id: "a", Type: "sentry"}, {Id: "a", Type: "sentinel"}}, false},
+		{"peers with the same name", Peers{{Id: "a", Name: "erigon"}, {Id: "b", Name: "erigon"}}, true},
+		{"peer of unknown type", Peers{{Id: "a", Type: "light"}}, false},
+
+		{"flags", Flags{"chain": "mainnet", "http": true}, true},
+		{"no flags set", Flags{}, true},
+		{"flags missing", Flags(nil), false},
+		{"flag without a name", Flags{"chain": "mainnet", "": "x"}, false},
+
+		{"command line", CommandLine{"erigon", "--chain=mainnet"}, true},
+		{"program alone", CommandLine{"erigon"}, true},
+		{"empty command line", CommandLine{}, false},
+		{"command line without a program", CommandLine{"", "--chain=mainnet"}, false},
+
+		{"downloader", DownloaderStatus{Downloaded: 5, Total: 10, Segments: map[string]SegmentDownload{"s": {TotalBytes: 10, DownloadedBytes: 10}}}, true},
+		{"downloader without a total", DownloaderStatus{Downloaded: 5}, true},
+		{"downloaded past the total", DownloaderStatus{Downloaded: 11, Total: 10}, false},
+		{"negative peers", DownloaderStatus{Peers: -1}, false},
+		{"negative files", DownloaderStatus{Files: -1}, false},
+		{"negative torrents", DownloaderStatus{TorrentMetadataReady: -1}, false},
+		{"segment downloaded past its total", DownloaderStatus{Segments: map[string]SegmentDownload{"s": {TotalBytes: 10, DownloadedBytes: 11}}}, false},
+		{"snapshot sync", snapshotSync{DownloaderStatus{Downloaded: 5, Total: 10}}, true},
+		{"invalid snapshot sync", snapshotSync{DownloaderStatus{Downloaded: 11, Total: 10}}, false},
+
+		{"sysinfo", SysInfo{Disk: DiskInfo{Total: 10, Free: 5}, RAM: RAMInfo{Total: 10, Available: 4, Used: 6, UsedPercent: 60}, CPU: []CPUInfo{{Cores: 8, Mhz: 3000}}}, true},
+		{"no sysinfo", SysInfo{}, true},
+		{"disk free past its total", SysInfo{Disk: DiskInfo{Total: 10, Free: 11}}, false},
+		{"RAM available past its total", SysInfo{RAM: RAMInfo{Total: 10, Available: 11}}, false},
+		{"RAM used past its total", SysInfo{RAM: RAMInfo{Total: 10, Used: 11}}, false},
+		{"RAM used over 100%", SysInfo{RAM: RAMInfo{UsedPercent: 101}}, false},
+		{"negative RAM use", SysInfo{RAM: RAMInfo{UsedPercent: -1}}, false},
+		{"negative cores", SysInfo{CPU: []CPUInfo{{Cores: -1}}}, false},
+		{"negative MHz", SysInfo{CPU: []CPUInfo{{Mhz: -1}}}, false},
+
+		{"node info", NodesInfo{{Id: "a", Enode: "enode://a@127.0.0.1:30303", Ports: NodePorts{Discovery: 30303, Listener: 30303}}, {Id: "b"}}, true},
+		{"node info without id", NodesInfo{{Enode: "enode://a@127.0.0.1:30303"}}, false},
+		{"negative port", NodesInfo{{Id: "a", Ports: NodePorts{Discovery: -1}}}, false},
+		{"port past 65535", NodesInfo{{Id: "a", Ports: NodePorts{Listener: 65536}}}, false},
+		{"invalid enode", NodesInfo{{Id: "a", Enode: "enr:-abc"}}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.data.Validate(); (err == nil) != tt.valid {
+				t.Fatalf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// dataClient returns a client of a node which answers each method with its result
+func dataClient(ctx context.Context, results map[string]string) Client {
+	requests := make(chan *NodeRequest)
+	client := NewClient("node", requests)
+	client.SetConnected(true)
+
+	go func() {
+		for {
+			select {
+			case request := <-requests:
+				response := &Response{Id: request.Request.Id, Result: json.RawMessage(results[request.Request.Method]), Last: true}
+				request.Deliver(ctx, response)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return client
+}
+
+func TestFetchNodeData(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		method string
+		result string
+		fetch  func(ctx context.Context, client Client) (interface{}, error)
+		valid  bool
+	}{
+		{"peers", "peers", `[{"id":"a","type":"sentry"},{"id":"b","type":"sentinel"}]`, fetchPeers, true},
+		{"peers listed twice", "peers", `[{"id":"a","type":"sentry"},{"id":"a","type":"sentry"}]`, fetchPeers, false},
+		{"peers not a list", "peers", `{"id":"a"}`, fetchPeers, false},
+		{"peer with a numeric id", "peers", `[{"id":1}]`, fetchPeers, false},
+		{"truncated peers", "peers", `[{"id":"a"`, fetchPeers, false},
+
+		{"flags", "flags", `{"chain":"mainnet","http.port":8545}`, fetchFlags, true},
+		{"null flags", "flags", `null`, fetchFlags, false},
+		{"flags a list", "flags", `["chain"]`, fetchFlags, false},
+		{"flag without a name", "flags", `{"":1}`, fetchFlags, false},
+
+		{"command line", "cmdline", `["erigon","--chain=mainnet"]`, fetchCommandLine, true},
+		{"empty command line", "cmdline", `[]`, fetchCommandLine, false},
+		{"command line a string", "cmdline", `"erigon --chain=mainnet"`, fetchCommandLine, false},
+
+		{"downloader", "snapshot-sync", `{"snapshotDownload":{"downloaded":5,"total":10,"peers":3}}`, fetchDownloader, true},
+		{"downloaded past the total", "snapshot-sync", `{"snapshotDownload":{"downloaded":11,"total":10}}`, fetchDownloader, false},
+		{"downloader negative size", "snapshot-sync", `{"snapshotDownload":{"downloaded":-1}}`, fetchDownloader, false},
+
+		{"sysinfo", "hardware-info", `{"disk":{"total":10,"free":5},"ram":{"total":10,"used":6,"usedPercent":60}}`, fetchSysInfo, true},
+		{"RAM used past its total", "hardware-info", `{"ram":{"total":10,"used":11}}`, fetchSysInfo, false},
+		{"sysinfo with a text size", "hardware-info", `{"disk":{"total":"10GB"}}`, fetchSysInfo, false},
+
+		{"node info", "nodeinfo", `[{"id":"a","enode":"enode://a@127.0.0.1:30303","ports":{"discovery":30303,"listener":30303}}]`, fetchNodeInfo, true},
+		{"node info invalid port", "nodeinfo", `[{"id":"a","ports":{"listener":70000}}]`, fetchNodeInfo, false},
+		{"node info not JSON", "nodeinfo", `<html>`, fetchNodeInfo, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := tt.fetch(ctx, dataClient(ctx, map[string]string{tt.method: tt.result}))
+
+			if tt.valid && err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.valid && !errors.Is(err, ErrInvalidNodeData) {
+				t.Fatalf("got %v, want %v", err, ErrInvalidNodeData)
+			}
+		})
+	}
+}
+
+func fetchPeers(ctx context.Context, client Client) (interface{}, error) {
+	return client.Peers(ctx)
+}
+
+func fetchFlags(ctx context.Context, client Client) (interface{}, error) {
+	return client.Flags(ctx)
+}
+
+func fetchCommandLine(ctx context.Context, client Client) (interface{}, error) {
+	return client.CommandLine(ctx)
+}
+
+func fetchDownloader(ctx context.Context, client Client) (interface{}, error) {
+	return client.DownloaderStatus(ctx)
+}
+
+func fetchSysInfo(ctx context.Context, client Client) (interface{}, error) {
+	return client.SysInfo(ctx)
+}
+
+func fetchNodeInfo(ctx context.Context, client Client) (interface{}, error) {
+	return client.NodeInfo(ctx)
+}
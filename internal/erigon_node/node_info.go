@@ -0,0 +1,54 @@
+package erigon_node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NodeInfo describes one of the p2p servers of the node, which runs one per protocol version
+type NodeInfo struct {
+	Id           string          `json:"id"`
+	Name         string          `json:"name"`
+	Enode        string          `json:"enode"`
+	ENR          string          `json:"enr"`
+	Ports        NodePorts       `json:"ports"`
+	ListenerAddr string          `json:"listenAddr"`
+	Protocols    json.RawMessage `json:"protocols,omitempty"`
+}
+
+type NodePorts struct {
+	Discovery int `json:"discovery"`
+	Listener  int `json:"listener"`
+}
+
+type NodesInfo []NodeInfo
+
+// Validate requires each server to have an id, valid ports and an enode URL when it has an enode
+func (n NodesInfo) Validate() error {
+	for i, info := range n {
+		if info.Id == "" {
+			return fmt.Errorf("node info %d has no id", i)
+		}
+
+		for _, port := range []int{info.Ports.Discovery, info.Ports.Listener} {
+			if port < 0 || port > 65535 {
+				return fmt.Errorf("node %s has invalid port %d", info.Id, port)
+			}
+		}
+
+		if info.Enode != "" && !strings.HasPrefix(info.Enode, "enode://") {
+			return fmt.Errorf("node %s has invalid enode %q", info.Id, info.Enode)
+		}
+	}
+
+	return nil
+}
+
+// NodeInfo returns the identities and addresses of the node's p2p servers
+func (c *NodeClient) NodeInfo(ctx context.Context) (NodesInfo, error) {
+	var info NodesInfo
+	err := c.fetchData(ctx, "nodeinfo", &info)
+	return info, err
+}
@@ -3,10 +3,21 @@ package erigon_node
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"time"
 )
 
+// CancelMethod asks the node to abandon the in-flight request with the same id
+const CancelMethod = "cancel"
+
+// ErrorCodeNodeDisconnected is the error code of the responses which fail requests
+// that were still in flight when the bridge to their node closed
+const ErrorCodeNodeDisconnected int64 = -32001
+
+var ErrNodeDisconnected = errors.New("node disconnected")
+
 type Params struct {
 	NodeId      string     `json:"nodeId"`
 	QueryParams url.Values `json:"queryParams,omitempty"`
@@ -16,7 +27,9 @@ type Request struct {
 	Method string  `json:"method"`
 	Id     string  `json:"id"`
 	Params *Params `json:"params,omitempty"`
-	Notif  bool    `json:"-"`
+	// Deadline tells the node when the caller stops waiting for the responses, if ever
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Notif    bool       `json:"-"`
 }
 
 type Response struct {
@@ -24,6 +37,8 @@ type Response struct {
 	Result json.RawMessage `json:"result,omitempty"`
 	Error  *Error          `json:"error,omitempty"`
 	Last   bool            `json:"last,omitempty"`
+	// Binary holds the raw bytes of results sent in raw chunks, see ChunkHeader
+	Binary []byte `json:"-"`
 }
 
 type Error struct {
@@ -36,21 +51,91 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%d: %s", e.Code, e.Message)
 }
 
+// Is matches ErrNodeDisconnected for the errors which fail requests to a disconnected node
+func (e *Error) Is(target error) bool {
+	return target == ErrNodeDisconnected && e.Code == ErrorCodeNodeDisconnected
+}
+
+func NodeDisconnectedError() *Error {
+	return &Error{
+		Code:    ErrorCodeNodeDisconnected,
+		Message: ErrNodeDisconnected.Error(),
+	}
+}
+
 type NodeRequest struct {
 	Request   *Request
 	Responses chan *Response
-	Retries   uint
+
+	ctx context.Context // the caller's context, done once nobody waits for the responses
+}
+
+// Done is closed when the caller of the request stops waiting for its responses
+func (n *NodeRequest) Done() <-chan struct{} {
+	if n.ctx == nil {
+		return nil
+	}
+
+	return n.ctx.Done()
+}
+
+// Deliver passes the response to the caller of the request, it returns false without
+// delivering it if the caller has stopped waiting or ctx is done first
+func (n *NodeRequest) Deliver(ctx context.Context, response *Response) bool {
+	select {
+	case n.Responses <- response:
+		return true
+	case <-n.Done():
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Fail completes the request with the error, without waiting for the caller to receive it
+func (n *NodeRequest) Fail(err *Error) {
+	go n.Deliver(context.Background(), &Response{
+		Id:    n.Request.Id,
+		Error: err,
+		Last:  true,
+	})
 }
 
 func (n *NodeRequest) nextResult(ctx context.Context) (bool, json.RawMessage, error) {
+	response, err := n.nextResponse(ctx)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	return !response.Last, response.Result, nil
+}
+
+func (n *NodeRequest) nextResponse(ctx context.Context) (*Response, error) {
 	select {
 	case <-ctx.Done():
-		return false, nil, ctx.Err()
+		return nil, ctx.Err()
 	case response := <-n.Responses:
 		if response.Error != nil {
-			return false, nil, response.Error
+			return nil, response.Error
+		}
+
+		return response, nil
+	}
+}
+
+// decodeContent unmarshals the result of a response carrying content, such as a log or
+// profile chunk, which the node may have sent as raw bytes rather than in the JSON result
+func decodeContent(response *Response, result interface{}, content *[]byte) error {
+	if len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return err
 		}
+	}
 
-		return !response.Last, response.Result, nil
+	if response.Binary != nil {
+		*content = response.Binary
 	}
+
+	return nil
 }
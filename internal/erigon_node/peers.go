@@ -0,0 +1,84 @@
+package erigon_node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Types of the peers of a node
+const (
+	PeerTypeSentry   = "sentry"   // execution layer peers
+	PeerTypeSentinel = "sentinel" // consensus layer peers
+)
+
+type PeerNetwork struct {
+	LocalAddress  string `json:"localAddress"`
+	RemoteAddress string `json:"remoteAddress"`
+	Inbound       bool   `json:"inbound"`
+	Trusted       bool   `json:"trusted"`
+	Static        bool   `json:"static"`
+}
+
+// Peer is a peer of the node with the traffic exchanged with it, in bytes by
+// capability and by message type
+type Peer struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name"`
+	Type         string            `json:"type,omitempty"`
+	ENR          string            `json:"enr,omitempty"`
+	Enode        string            `json:"enode,omitempty"`
+	Caps         []string          `json:"caps"`
+	Network      PeerNetwork       `json:"network"`
+	BytesIn      uint64            `json:"bytesIn"`
+	BytesOut     uint64            `json:"bytesOut"`
+	CapBytesIn   map[string]uint64 `json:"capBytesIn,omitempty"`
+	CapBytesOut  map[string]uint64 `json:"capBytesOut,omitempty"`
+	TypeBytesIn  map[string]uint64 `json:"typeBytesIn,omitempty"`
+	TypeBytesOut map[string]uint64 `json:"typeBytesOut,omitempty"`
+}
+
+type Peers []Peer
+
+// Validate requires each peer to have a unique id and a known type, when it has one
+func (p Peers) Validate() error {
+	ids := make(map[string]struct{}, len(p))
+
+	for i, peer := range p {
+		if peer.Id == "" {
+			return fmt.Errorf("peer %d has no id", i)
+		}
+
+		if _, ok := ids[peer.Id]; ok {
+			return fmt.Errorf("peer %s is listed twice", peer.Id)
+		}
+
+		ids[peer.Id] = struct{}{}
+
+		if peer.Type != "" && !strings.EqualFold(peer.Type, PeerTypeSentry) && !strings.EqualFold(peer.Type, PeerTypeSentinel) {
+			return fmt.Errorf("peer %s has unknown type %q", peer.Id, peer.Type)
+		}
+	}
+
+	return nil
+}
+
+// OfType returns the peers of the given type
+func (p Peers) OfType(peerType string) Peers {
+	peers := Peers{}
+
+	for _, peer := range p {
+		if strings.EqualFold(peer.Type, peerType) {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// Peers returns the sentry and sentinel peers of the node
+func (c *NodeClient) Peers(ctx context.Context) (Peers, error) {
+	var peers Peers
+	err := c.fetchData(ctx, "peers", &peers)
+	return peers, err
+}
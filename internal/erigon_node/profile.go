@@ -2,7 +2,6 @@ package erigon_node
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -19,7 +18,7 @@ func (c *NodeClient) FindProfile(ctx context.Context, profile string) ([]byte, e
 		return nil, fmt.Errorf("fetching profile: %w", err)
 	}
 
-	_, result, err := request.nextResult(ctx)
+	response, err := request.nextResponse(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("fetching profile content: %w", err)
@@ -27,7 +26,7 @@ func (c *NodeClient) FindProfile(ctx context.Context, profile string) ([]byte, e
 
 	var content ProfileContent
 
-	if err := json.Unmarshal(result, &content); err != nil {
+	if err := decodeContent(response, &content, &content.Chunk); err != nil {
 		return nil, fmt.Errorf("unmarshalling profile content: %w", err)
 	}
 
@@ -1,10 +1,13 @@
 package erigon_node
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"strconv"
 )
 
 type Tables []Table
@@ -15,7 +18,32 @@ type Table struct {
 	Size  uint64 `json:"size"`
 }
 
-type Results struct{}
+// KeyValue is a single raw row read from a remote table
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// TableQuery bounds the page of rows returned by Table. StartKey is inclusive,
+// EndKey is exclusive, and rows must carry Prefix when it is set
+type TableQuery struct {
+	StartKey []byte
+	EndKey   []byte
+	Prefix   []byte
+	Limit    int
+}
+
+const (
+	defaultTableLimit = 100
+	maxTableLimit     = 1000
+)
+
+// Results is a page of rows read from a remote table; NextKey is the key to
+// pass as StartKey to read the following page and is nil once the range is exhausted
+type Results struct {
+	Rows    []KeyValue
+	NextKey []byte
+}
 
 func (c *NodeClient) Tables(ctx context.Context, db string) (Tables, error) {
 	request, err := c.fetch(ctx, "dbs/"+db+"/tables", nil)
@@ -39,13 +67,71 @@ func (c *NodeClient) Tables(ctx context.Context, db string) (Tables, error) {
 	return tables, nil
 }
 
-func (c *NodeClient) Table(ctx context.Context, db string, table string) (Results, error) {
-	return Results{}, fmt.Errorf("TODO")
+func (c *NodeClient) Table(ctx context.Context, db string, table string, query TableQuery) (Results, error) {
+	limit := query.Limit
+
+	if limit <= 0 {
+		limit = defaultTableLimit
+	}
+
+	if limit > maxTableLimit {
+		limit = maxTableLimit
+	}
+
+	startKey := query.StartKey
+
+	if bytes.Compare(startKey, query.Prefix) < 0 {
+		startKey = query.Prefix
+	}
+
+	// one row past the limit is read to find the key the next page starts at
+	rc := NewRemoteCursor(c, WithChunkSize(limit+1))
+	defer rc.Close()
+
+	if err := rc.Init(ctx, db, table, startKey); err != nil {
+		return Results{}, fmt.Errorf("could not initialize remote cursor: %w", err)
+	}
+
+	var results Results
+
+	for {
+		k, v, err := rc.Next(ctx)
+
+		if err != nil {
+			return Results{}, fmt.Errorf("reading %s table: %w", table, err)
+		}
+
+		if k == nil || !query.contains(k) {
+			break
+		}
+
+		if len(results.Rows) == limit {
+			results.NextKey = k
+			break
+		}
+
+		results.Rows = append(results.Rows, KeyValue{Key: k, Value: v})
+	}
+
+	return results, nil
+}
+
+func (q TableQuery) contains(key []byte) bool {
+	if len(q.EndKey) > 0 && bytes.Compare(key, q.EndKey) >= 0 {
+		return false
+	}
+
+	return bytes.HasPrefix(key, q.Prefix)
 }
 
 type RemoteDbReader interface {
 	Init(ctx context.Context, db string, table string, initialKey []byte) error
 	Next(ctx context.Context) ([]byte, []byte, error)
+	Prev(ctx context.Context) ([]byte, []byte, error)
+	Seek(ctx context.Context, key []byte) ([]byte, []byte, error)
+	Last(ctx context.Context) ([]byte, []byte, error)
+	Range(ctx context.Context, from []byte, to []byte) error
+	Close()
 }
 
 type results [][2][]byte
@@ -110,15 +196,68 @@ func tostr(json []byte) (pos int, str string) {
 	return len(json), string(json[1:])
 }
 
+// defaultChunkSize is the number of rows requested from the node per table read
+// unless the cursor is created with WithChunkSize
+const defaultChunkSize = 256
+
+// minBisectLength is the shortest key length used when bisecting the key space,
+// chosen so that tables keyed by block number converge quickly
+const minBisectLength = 8
+
+// maxEmptyProbes is the number of probes finding no rows after which findPrevious
+// reads from the lowest key the rows may be at, rather than bisect further
+const maxEmptyProbes = 2
+
 type RemoteCursor struct {
-	nodeClient Client
-	dbPath     string
-	table      string
-	results    results
+	nodeClient    Client
+	dbPath        string
+	table         string
+	chunkSize     int
+	prefetchDepth int
+	prefetched    chan tableChunk    // chunks read ahead of results, nil when not prefetching
+	stopPrefetch  context.CancelFunc // stops the goroutine filling prefetched
+	origin        []byte             // key the cursor was last positioned at
+	upperBound    []byte             // exclusive upper key set by Range, nil when unbounded
+	current       *[2][]byte         // row last returned by the cursor
+	results       results            // rows following current, in ascending order
+	previous      results            // rows preceding current, in ascending order
+}
+
+// tableChunk is a chunk of rows read ahead by the prefetch goroutine
+type tableChunk struct {
+	results results
+	err     error
+}
+
+// RemoteCursorOption configures a RemoteCursor created by NewRemoteCursor
+type RemoteCursorOption func(rc *RemoteCursor)
+
+// WithChunkSize sets the number of rows requested from the node per table read
+func WithChunkSize(size int) RemoteCursorOption {
+	return func(rc *RemoteCursor) {
+		if size > 0 {
+			rc.chunkSize = size
+		}
+	}
 }
 
-func NewRemoteCursor(nodeClient Client) *RemoteCursor {
-	rc := &RemoteCursor{nodeClient: nodeClient}
+// WithPrefetch makes the cursor read up to depth chunks ahead in the background
+// once it is walked forwards with Next, so that a chunk is requested from the node
+// while the previous one is being consumed rather than after it has been drained
+func WithPrefetch(depth int) RemoteCursorOption {
+	return func(rc *RemoteCursor) {
+		if depth > 0 {
+			rc.prefetchDepth = depth
+		}
+	}
+}
+
+func NewRemoteCursor(nodeClient Client, options ...RemoteCursorOption) *RemoteCursor {
+	rc := &RemoteCursor{nodeClient: nodeClient, chunkSize: defaultChunkSize}
+
+	for _, option := range options {
+		option(rc)
+	}
 
 	return rc
 }
@@ -134,7 +273,7 @@ func (rc *RemoteCursor) Init(ctx context.Context, db string, table string, initi
 	rc.table = table
 	fmt.Println("Remote Cursor", rc.dbPath, rc.table)
 
-	if err := rc.nextTableChunk(ctx, initialKey); err != nil {
+	if err := rc.position(ctx, initialKey, nil); err != nil {
 		return err
 	}
 
@@ -177,17 +316,82 @@ func (rc *RemoteCursor) findFullDbPath(ctx context.Context, db string) (string,
 	return dbPath, nil
 }
 
-func (rc *RemoteCursor) nextTableChunk(ctx context.Context, startKey []byte) error {
-	request, err := rc.nodeClient.fetch(ctx, "dbs/"+rc.dbPath+"/tables/"+rc.table+"/"+base64.URLEncoding.EncodeToString(startKey)+"?limit=256", nil)
+// position moves the cursor to just before the first row with a key >= key,
+// so that the following call to Next returns it
+func (rc *RemoteCursor) position(ctx context.Context, key []byte, upperBound []byte) error {
+	rc.Close()
+
+	rc.origin = key
+	rc.upperBound = upperBound
+	rc.current = nil
+	rc.previous = nil
+	rc.results = nil
+
+	return rc.nextTableChunk(ctx, key)
+}
+
+// prefetch starts a goroutine which reads the chunks following the one ending with
+// lastKey into a channel holding up to prefetchDepth of them. It stops at the end
+// of the table or the cursor's upper bound, on error, or once the cursor is
+// repositioned or closed
+func (rc *RemoteCursor) prefetch(ctx context.Context, lastKey []byte) {
+	ctx, cancel := context.WithCancel(ctx)
+	chunks := make(chan tableChunk, rc.prefetchDepth)
+	upperBound := rc.upperBound
+
+	rc.prefetched = chunks
+	rc.stopPrefetch = cancel
+
+	go func() {
+		defer close(chunks)
+
+		for {
+			if upperBound != nil && bytes.Compare(lastKey, upperBound) >= 0 {
+				return
+			}
+
+			results, err := rc.fetchTableChunk(ctx, advance(lastKey))
+
+			if err == nil && len(results) == 0 {
+				return
+			}
+
+			select {
+			case chunks <- tableChunk{results: results, err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			lastKey = results[len(results)-1][0]
+		}
+	}()
+}
+
+// Close stops any background reads made by the cursor
+func (rc *RemoteCursor) Close() {
+	if rc.stopPrefetch != nil {
+		rc.stopPrefetch()
+	}
+
+	rc.prefetched = nil
+	rc.stopPrefetch = nil
+}
+
+func (rc *RemoteCursor) fetchTableChunk(ctx context.Context, startKey []byte) (results, error) {
+	request, err := rc.nodeClient.fetch(ctx, "dbs/"+rc.dbPath+"/tables/"+rc.table+"/"+base64.URLEncoding.EncodeToString(startKey)+"?limit="+strconv.Itoa(rc.chunkSize), nil)
 
 	if err != nil {
-		return fmt.Errorf("reading %s table: %w", rc.table, err)
+		return nil, fmt.Errorf("reading %s table: %w", rc.table, err)
 	}
 
 	_, result, err := request.nextResult(ctx)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var results struct {
@@ -199,51 +403,385 @@ func (rc *RemoteCursor) nextTableChunk(ctx context.Context, startKey []byte) err
 
 	err = json.Unmarshal(result, &results)
 
+	if err != nil {
+		return nil, err
+	}
+
+	return results.Results, nil
+}
+
+func (rc *RemoteCursor) nextTableChunk(ctx context.Context, startKey []byte) error {
+	results, err := rc.fetchTableChunk(ctx, startKey)
+
 	if err != nil {
 		return err
 	}
 
-	rc.results = results.Results
+	rc.results = results
 	return nil
 }
 
+// advance returns the smallest key ordered after key. Appending a zero byte
+// rather than incrementing the last one keeps keys which have key as a prefix
+// in range, as tables such as SyncStage have keys of varying length
 func advance(key []byte) []byte {
-	if len(key) == 0 {
-		return []byte{0}
-	}
-	i := len(key) - 1
-	for i >= 0 && key[i] == 0xff {
-		i--
+	key1 := make([]byte, len(key)+1)
+	copy(key1, key)
+	return key1
+}
+
+// keyNum returns key as a big-endian number, right-padded with zeros to length
+// bytes. Padding keys to a common length preserves their lexicographic order
+func keyNum(key []byte, length int) *big.Int {
+	padded := make([]byte, length)
+	copy(padded, key)
+	return new(big.Int).SetBytes(padded)
+}
+
+// bisect returns a key of the given length halfway between lo and hi, where a nil
+// hi stands for the end of the key space. When span, the key distance covered by
+// the last full chunk read, is set the key is no further than span << gallop beyond
+// lo, and lo itself is returned once the range is no wider than span, as a single
+// chunk read from lo is then likely to cover the whole of it
+func bisect(lo []byte, hi []byte, length int, span *big.Int, gallop uint) ([]byte, bool) {
+	if hi != nil && bytes.Compare(lo, hi) >= 0 {
+		return nil, false
 	}
-	var key1 []byte
-	if i < 0 {
-		key1 = make([]byte, len(key)+1)
+
+	loNum := keyNum(lo, length)
+
+	var hiNum *big.Int
+
+	if hi == nil {
+		hiNum = new(big.Int).Lsh(big.NewInt(1), uint(8*length))
 	} else {
-		key1 = make([]byte, len(key))
+		hiNum = keyNum(hi, length)
 	}
-	copy(key1, key)
-	if i >= 0 {
-		key1[i]++
+
+	mid := new(big.Int).Add(loNum, hiNum)
+	mid.Rsh(mid, 1)
+
+	if span != nil {
+		if new(big.Int).Sub(hiNum, loNum).Cmp(span) <= 0 {
+			return lo, true
+		}
+
+		if limit := new(big.Int).Add(loNum, new(big.Int).Lsh(span, gallop)); limit.Cmp(mid) < 0 {
+			mid = limit
+		}
 	}
-	return key1
+
+	if mid.Cmp(loNum) <= 0 {
+		// lo and hi are adjacent at this length - the only keys left to
+		// look at start with lo itself
+		return lo, true
+	}
+
+	return mid.FillBytes(make([]byte, length)), true
 }
 
-func (rc *RemoteCursor) Next(ctx context.Context) ([]byte, []byte, error) {
+// findPrevious returns, in ascending order, a run of rows which ends with the
+// last row having a key lower than bound, or with the last row of the table when
+// bound is nil. The node can only read tables forwards, so rather than scanning
+// from the start of the table the key space below bound is bisected, reading one
+// chunk per probe, until a chunk is found which stops short of the bound. While
+// probes keep returning full chunks the search gallops forwards, doubling how far
+// beyond the last of them it probes next
+func (rc *RemoteCursor) findPrevious(ctx context.Context, bound []byte) (results, error) {
+	var found results
+	var lo []byte
+	var spanFrom, spanTo []byte
+	var gallop uint
+
+	hi := bound
+
+	// when looking for the last row start with the beginning of the table,
+	// which settles empty and small tables with a single read
+	probeLo := bound == nil
+
+	// probes finding no rows below bound in a row, lo is probed after a few of them so
+	// that a bound at or near the first row does not take a bisection down to it
+	var emptyProbes int
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		probe, ok := lo, true
+
+		if !probeLo {
+			length := max(len(lo), len(hi), len(spanTo), minBisectLength)
+
+			var span *big.Int
+
+			if spanTo != nil {
+				span = new(big.Int).Sub(keyNum(spanTo, length), keyNum(spanFrom, length))
+			}
+
+			probe, ok = bisect(lo, hi, length, span, gallop)
+		}
+
+		probeLo = false
+
+		if !ok {
+			return found, nil
+		}
+
+		chunk, err := rc.fetchTableChunk(ctx, probe)
+
+		if err != nil {
+			return nil, err
+		}
+
+		below := chunk
+
+		if bound != nil {
+			for i, row := range chunk {
+				if bytes.Compare(row[0], bound) >= 0 {
+					below = chunk[:i]
+					break
+				}
+			}
+		}
+
+		if len(below) == 0 {
+			// there are no rows in [probe, bound) so the one we are
+			// looking for is either already found or lies below the probe
+			if bytes.Equal(probe, lo) {
+				return found, nil
+			}
+
+			hi = probe
+			emptyProbes++
+			probeLo = emptyProbes >= maxEmptyProbes
+			continue
+		}
+
+		emptyProbes = 0
+
+		found = below
+
+		if len(below) < len(chunk) || len(chunk) < rc.chunkSize {
+			return rc.extendPrevious(ctx, probe, chunk, found)
+		}
+
+		spanFrom, spanTo = probe, below[len(below)-1][0]
+		gallop++
+
+		lo = advance(spanTo)
+	}
+}
+
+// extendPrevious prepends to found, the rows from probe up to the bound, the rows below it when
+// they can be had with one more read. A search often ends with a probe just below the bound,
+// which would leave Prev to search again after a few rows, so the read starts from a key below
+// probe estimated from the key density of chunk, to return about a chunk of rows. The rows
+// read are only used when they reach found, so that no rows between them are missed
+func (rc *RemoteCursor) extendPrevious(ctx context.Context, probe []byte, chunk results, found results) (results, error) {
+	missing := rc.chunkSize - len(found)
+
+	if missing <= 0 || len(probe) == 0 || len(chunk) < 2 {
+		return found, nil
+	}
+
+	last := chunk[len(chunk)-1][0]
+	length := max(len(probe), len(last), minBisectLength)
+	probeNum := keyNum(probe, length)
+
+	perRow := new(big.Int).Sub(keyNum(last, length), probeNum)
+	perRow.Div(perRow, big.NewInt(int64(len(chunk)-1)))
+
+	if perRow.Sign() <= 0 {
+		return found, nil
+	}
+
+	start := new(big.Int).Sub(probeNum, perRow.Mul(perRow, big.NewInt(int64(missing))))
+
+	if start.Sign() < 0 {
+		start.SetInt64(0)
+	}
+
+	extension, err := rc.fetchTableChunk(ctx, start.FillBytes(make([]byte, length)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range extension {
+		if bytes.Compare(row[0], found[0][0]) >= 0 {
+			return append(extension[:i:i], found...), nil
+		}
+	}
+
+	// the rows read stop short of found, there may be more between them
+	return found, nil
+}
+
+func (rc *RemoteCursor) checkInitialized() error {
 	if rc.dbPath == "" || rc.table == "" {
-		return nil, nil, fmt.Errorf("cursor not initialized")
+		return fmt.Errorf("cursor not initialized")
+	}
+
+	return nil
+}
+
+func (rc *RemoteCursor) Next(ctx context.Context) ([]byte, []byte, error) {
+	if err := rc.checkInitialized(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(rc.results) == 0 && rc.current != nil {
+		if err := rc.readAhead(ctx); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	if len(rc.results) == 0 {
 		return nil, nil, nil
 	}
+
+	if rc.prefetchDepth > 0 && rc.prefetched == nil {
+		rc.prefetch(ctx, rc.results[len(rc.results)-1][0])
+	}
+
 	result := rc.results[0]
 
+	if rc.upperBound != nil && bytes.Compare(result[0], rc.upperBound) >= 0 {
+		return nil, nil, nil
+	}
+
 	rc.results = rc.results[1:]
 
-	if len(rc.results) == 0 {
-		if e := rc.nextTableChunk(ctx, advance(result[0])); e != nil {
-			return result[0], result[1], e
+	if rc.current != nil {
+		rc.previous = append(rc.previous, *rc.current)
+
+		if len(rc.previous) > rc.chunkSize {
+			rc.previous = rc.previous[len(rc.previous)-rc.chunkSize:]
 		}
 	}
+
+	rc.current = &result
 	return result[0], result[1], nil
 }
+
+// readAhead refills results with the rows following current, taking the next
+// prefetched chunk when prefetching and reading it from the node otherwise
+func (rc *RemoteCursor) readAhead(ctx context.Context) error {
+	if rc.prefetched == nil {
+		return rc.nextTableChunk(ctx, advance(rc.current[0]))
+	}
+
+	select {
+	case chunk, ok := <-rc.prefetched:
+		if !ok {
+			// the end of the table or range has been reached
+			return nil
+		}
+
+		if chunk.err != nil {
+			rc.Close()
+			return chunk.err
+		}
+
+		rc.results = chunk.results
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Prev moves the cursor back to the row preceding the one it last returned,
+// or the key it was positioned at, and returns it. It returns a nil key once the
+// start of the table is reached
+func (rc *RemoteCursor) Prev(ctx context.Context) ([]byte, []byte, error) {
+	if err := rc.checkInitialized(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(rc.previous) == 0 {
+		bound := rc.origin
+
+		if rc.current != nil {
+			bound = rc.current[0]
+		}
+
+		if len(bound) == 0 {
+			return nil, nil, nil
+		}
+
+		previous, err := rc.findPrevious(ctx, bound)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(previous) == 0 {
+			return nil, nil, nil
+		}
+
+		rc.previous = previous
+	}
+
+	result := rc.previous[len(rc.previous)-1]
+	rc.previous = rc.previous[:len(rc.previous)-1]
+
+	if rc.current != nil {
+		rc.results = append(results{*rc.current}, rc.results...)
+	}
+
+	rc.current = &result
+	return result[0], result[1], nil
+}
+
+// Seek positions the cursor at the first row with a key >= key and returns it
+func (rc *RemoteCursor) Seek(ctx context.Context, key []byte) ([]byte, []byte, error) {
+	if err := rc.checkInitialized(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := rc.position(ctx, key, nil); err != nil {
+		return nil, nil, err
+	}
+
+	return rc.Next(ctx)
+}
+
+// Last positions the cursor at the last row of the table and returns it
+func (rc *RemoteCursor) Last(ctx context.Context) ([]byte, []byte, error) {
+	if err := rc.checkInitialized(); err != nil {
+		return nil, nil, err
+	}
+
+	previous, err := rc.findPrevious(ctx, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rc.Close()
+	rc.origin = nil
+	rc.upperBound = nil
+	rc.current = nil
+	rc.results = nil
+	rc.previous = previous
+
+	if len(previous) == 0 {
+		return nil, nil, nil
+	}
+
+	return rc.Prev(ctx)
+}
+
+// Range restricts the cursor to rows with keys in [from, to) and positions it
+// before from, so that Next walks the range and returns a nil key once it is
+// exhausted. A nil to leaves the range open ended
+func (rc *RemoteCursor) Range(ctx context.Context, from []byte, to []byte) error {
+	if err := rc.checkInitialized(); err != nil {
+		return err
+	}
+
+	return rc.position(ctx, from, to)
+}
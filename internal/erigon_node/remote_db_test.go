@@ -0,0 +1,375 @@
+package erigon_node
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testDb = "chaindata"
+
+// fakeTable serves the rows of a sorted table to a NodeClient, as a node does over the bridge
+type fakeTable struct {
+	rows  [][2][]byte
+	reads atomic.Int64 // table chunks read
+}
+
+func newFakeTable(keys ...[]byte) *fakeTable {
+	table := &fakeTable{}
+
+	for i, key := range keys {
+		table.rows = append(table.rows, [2][]byte{key, []byte(strconv.Itoa(i))})
+	}
+
+	sort.Slice(table.rows, func(i, j int) bool {
+		return bytes.Compare(table.rows[i][0], table.rows[j][0]) < 0
+	})
+
+	return table
+}
+
+// client returns a client of a node serving the table until ctx is done
+func (f *fakeTable) client(ctx context.Context) Client {
+	requests := make(chan *NodeRequest)
+	client := NewClient("node", requests)
+	client.SetConnected(true)
+
+	go func() {
+		for {
+			select {
+			case request := <-requests:
+				request.Deliver(ctx, f.respond(request.Request))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return client
+}
+
+func (f *fakeTable) respond(request *Request) *Response {
+	response := &Response{Id: request.Id, Last: true}
+
+	if request.Method == "dbs" {
+		response.Result, _ = json.Marshal([]string{testDb})
+		return response
+	}
+
+	method, query, _ := strings.Cut(request.Method, "?")
+	encodedKey := method[strings.LastIndex(method, "/")+1:]
+	startKey, err := base64.URLEncoding.DecodeString(encodedKey)
+
+	if err != nil {
+		response.Error = &Error{Message: err.Error()}
+		return response
+	}
+
+	limit, _ := strconv.Atoi(strings.TrimPrefix(query, "limit="))
+
+	f.reads.Add(1)
+
+	var results bytes.Buffer
+
+	results.WriteString(`{"results":{`)
+
+	count := 0
+
+	for _, row := range f.rows {
+		if bytes.Compare(row[0], startKey) < 0 || count == limit {
+			continue
+		}
+
+		if count > 0 {
+			results.WriteByte(',')
+		}
+
+		fmt.Fprintf(&results, "%q:%q", base64.URLEncoding.EncodeToString(row[0]), base64.URLEncoding.EncodeToString(row[1]))
+		count++
+	}
+
+	results.WriteString(`}}`)
+	response.Result = results.Bytes()
+	return response
+}
+
+func testCursor(t *testing.T, table *fakeTable, options ...RemoteCursorOption) (context.Context, *RemoteCursor) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	rc := NewRemoteCursor(table.client(ctx), options...)
+	t.Cleanup(rc.Close)
+
+	if err := rc.Init(ctx, testDb, "Table", nil); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	return ctx, rc
+}
+
+func hexKeys(keys ...string) [][]byte {
+	decoded := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		for j := 0; j < len(key); j += 2 {
+			b, err := strconv.ParseUint(key[j:j+2], 16, 8)
+
+			if err != nil {
+				panic(err)
+			}
+
+			decoded[i] = append(decoded[i], byte(b))
+		}
+	}
+
+	return decoded
+}
+
+func blockKeys(from, to uint64, step uint64) [][]byte {
+	var keys [][]byte
+
+	for n := from; n < to; n += step {
+		keys = append(keys, []byte{byte(n >> 56), byte(n >> 48), byte(n >> 40), byte(n >> 32), byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	}
+
+	return keys
+}
+
+var cursorTables = []struct {
+	name string
+	keys [][]byte
+}{
+	{"empty", nil},
+	{"single row", [][]byte{[]byte("a")}},
+	{"single empty key", [][]byte{{}}},
+	{"prefixes", [][]byte{[]byte("a"), []byte("aa"), []byte("aaa"), []byte("ab"), []byte("b"), []byte("ba")}},
+	{"0xff suffixes", hexKeys("00", "01", "01ff", "01ffff", "02", "ff", "ffff", "ffffff")},
+	{"zero suffixes", hexKeys("01", "0100", "010000", "0101")},
+	{"block numbers", blockKeys(0, 100, 1)},
+	{"sparse block numbers", blockKeys(1000, 1<<40, 1<<34)},
+	{"mixed lengths", append(blockKeys(10, 20, 3), hexKeys("00", "0000000000000003ff", "ff")...)},
+}
+
+var cursorChunkSizes = []int{1, 2, 3, 256}
+
+func tableKeys(table *fakeTable) [][]byte {
+	keys := make([][]byte, len(table.rows))
+
+	for i, row := range table.rows {
+		keys[i] = row[0]
+	}
+
+	return keys
+}
+
+func checkKeys(t *testing.T, what string, got [][]byte, want [][]byte) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d keys %x, want %d %x", what, len(got), got, len(want), want)
+	}
+
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("%s: key %d is %x, want %x", what, i, got[i], want[i])
+		}
+	}
+}
+
+func TestRemoteCursorNext(t *testing.T) {
+	for _, tt := range cursorTables {
+		for _, chunkSize := range cursorChunkSizes {
+			for _, prefetch := range []int{0, 2} {
+				t.Run(fmt.Sprintf("%s/chunk %d/prefetch %d", tt.name, chunkSize, prefetch), func(t *testing.T) {
+					table := newFakeTable(tt.keys...)
+					ctx, rc := testCursor(t, table, WithChunkSize(chunkSize), WithPrefetch(prefetch))
+
+					var keys [][]byte
+
+					for {
+						k, _, err := rc.Next(ctx)
+
+						if err != nil {
+							t.Fatalf("Next: %v", err)
+						}
+
+						if k == nil {
+							break
+						}
+
+						keys = append(keys, k)
+					}
+
+					checkKeys(t, "Next", keys, tableKeys(table))
+				})
+			}
+		}
+	}
+}
+
+func TestRemoteCursorLastPrev(t *testing.T) {
+	for _, tt := range cursorTables {
+		for _, chunkSize := range cursorChunkSizes {
+			t.Run(fmt.Sprintf("%s/chunk %d", tt.name, chunkSize), func(t *testing.T) {
+				table := newFakeTable(tt.keys...)
+				ctx, rc := testCursor(t, table, WithChunkSize(chunkSize))
+
+				k, _, err := rc.Last(ctx)
+
+				if err != nil {
+					t.Fatalf("Last: %v", err)
+				}
+
+				var keys [][]byte
+
+				for k != nil {
+					keys = append([][]byte{k}, keys...)
+
+					if k, _, err = rc.Prev(ctx); err != nil {
+						t.Fatalf("Prev: %v", err)
+					}
+				}
+
+				checkKeys(t, "Last then Prev", keys, tableKeys(table))
+
+				if reads := table.reads.Load(); reads > int64(8*len(table.rows)+8) {
+					t.Errorf("walking %d rows backwards read %d chunks", len(table.rows), reads)
+				}
+			})
+		}
+	}
+}
+
+func TestRemoteCursorSeekPrev(t *testing.T) {
+	for _, tt := range cursorTables {
+		for _, chunkSize := range cursorChunkSizes {
+			t.Run(fmt.Sprintf("%s/chunk %d", tt.name, chunkSize), func(t *testing.T) {
+				table := newFakeTable(tt.keys...)
+				keys := tableKeys(table)
+
+				for i, key := range keys {
+					ctx, rc := testCursor(t, table, WithChunkSize(chunkSize))
+
+					k, _, err := rc.Seek(ctx, key)
+
+					if err != nil || !bytes.Equal(k, key) {
+						t.Fatalf("Seek(%x) = %x, %v", key, k, err)
+					}
+
+					reads := table.reads.Load()
+					k, _, err = rc.Prev(ctx)
+
+					if err != nil {
+						t.Fatalf("Prev after Seek(%x): %v", key, err)
+					}
+
+					if i == 0 {
+						// Prev from the first row is the start of the table, found without bisecting down to it
+						if k != nil {
+							t.Fatalf("Prev from the first row %x = %x, want nil", key, k)
+						}
+
+						if reads := table.reads.Load() - reads; reads > maxEmptyProbes+1 {
+							t.Fatalf("Prev from the first row %x read %d chunks", key, reads)
+						}
+
+						continue
+					}
+
+					if !bytes.Equal(k, keys[i-1]) {
+						t.Fatalf("Prev after Seek(%x) = %x, want %x", key, k, keys[i-1])
+					}
+
+					// and forwards again
+					if k, _, err = rc.Next(ctx); err != nil || !bytes.Equal(k, key) {
+						t.Fatalf("Next after Prev = %x, %v, want %x", k, err, key)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRemoteCursorPrevBetweenKeys(t *testing.T) {
+	table := newFakeTable(cursorTables[4].keys...)
+
+	for _, tt := range []struct {
+		seek string
+		want string // hex
+	}{
+		{"0001", "00"},
+		{"0100", "01"},
+		{"01fffe", "01ff"},
+		{"01ffff00", "01ffff"},
+		{"03", "02"},
+		{"ffff00", "ffff"},
+	} {
+		t.Run(tt.seek, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			// positioned at the seek key, Prev returns the row below it
+			rc := NewRemoteCursor(table.client(ctx), WithChunkSize(2))
+			defer rc.Close()
+
+			if err := rc.Init(ctx, testDb, "Table", hexKeys(tt.seek)[0]); err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+
+			k, _, err := rc.Prev(ctx)
+
+			if err != nil || !bytes.Equal(k, hexKeys(tt.want)[0]) {
+				t.Fatalf("Prev from %s = %x, %v, want %s", tt.seek, k, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteCursorRange(t *testing.T) {
+	table := newFakeTable(cursorTables[3].keys...)
+	ctx, rc := testCursor(t, table, WithChunkSize(2))
+
+	if err := rc.Range(ctx, []byte("aa"), []byte("b")); err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+
+	var keys [][]byte
+
+	for {
+		k, _, err := rc.Next(ctx)
+
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		if k == nil {
+			break
+		}
+
+		keys = append(keys, k)
+	}
+
+	checkKeys(t, "Range", keys, [][]byte{[]byte("aa"), []byte("aaa"), []byte("ab")})
+}
+
+func TestAdvance(t *testing.T) {
+	for _, key := range hexKeys("", "00", "ff", "01ff", "ffff") {
+		next := advance(key)
+
+		if bytes.Compare(next, key) <= 0 {
+			t.Errorf("advance(%x) = %x is not after it", key, next)
+		}
+
+		if !bytes.HasPrefix(next, key) || len(next) != len(key)+1 || next[len(key)] != 0 {
+			t.Errorf("advance(%x) = %x is not the next key", key, next)
+		}
+	}
+}
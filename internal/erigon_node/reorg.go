@@ -1,8 +1,10 @@
 package erigon_node
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -14,37 +16,169 @@ const (
 	headersDb    = "chaindata"
 	headersTable = "Header"
 	maxCount     = 1000
+
+	// the Header table is scanned in large chunks, reading ahead while
+	// the current one is processed
+	reorgScanChunkSize = 1024
+	reorgScanPrefetch  = 4
 )
 
 type Reorg struct {
-	TotalScanned int      `json:"TotalScanned"`
-	WrongBlocks  []uint64 `json:"WrongBlocks"`
-	TimeTook     string   `json:"TimeTook"`
+	TotalScanned int           `json:"TotalScanned"`
+	WrongBlocks  []uint64      `json:"WrongBlocks"`
+	Forks        []ForkSegment `json:"Forks"`
+	MaxDepth     int           `json:"MaxDepth"`
+	TimeTook     string        `json:"TimeTook"`
+	Errors       []string      `json:"Errors,omitempty"`
+}
+
+// ReorgProgress is reported every maxCount rows while the Header table is scanned
+type ReorgProgress struct {
+	RowsScanned   int    `json:"RowsScanned"`
+	BlocksScanned int    `json:"BlocksScanned"`
+	CurrentBlock  uint64 `json:"CurrentBlock"`
+	ReorgsFound   int    `json:"ReorgsFound"`
+	Elapsed       string `json:"Elapsed"`
+}
+
+// ReorgProgressFunc receives the progress of a reorg scan, it is called
+// on the goroutine running the scan
+type ReorgProgressFunc func(progress ReorgProgress)
+
+// ForkHeader is a header found in the Header table which is not on the canonical chain
+type ForkHeader struct {
+	Number        uint64   `json:"Number"`
+	Hash          HexBytes `json:"Hash"`
+	ParentHash    HexBytes `json:"ParentHash"`
+	CanonicalHash HexBytes `json:"CanonicalHash"` // hash CanonicalHeader selected at the same height
+}
+
+// ForkSegment is a run of non-canonical headers, each building on the previous one,
+// which branches off another chain at ForkPoint. Depth counts the blocks from the tip
+// of the segment back to the canonical chain, including those of any fork it splits off
+type ForkSegment struct {
+	ForkPoint       uint64       `json:"ForkPoint"`       // number of the block the segment branches off
+	ForkHash        HexBytes     `json:"ForkHash"`        // hash of the block the segment branches off
+	ForkIsCanonical bool         `json:"ForkIsCanonical"` // false when branching off another fork or an unscanned block
+	Depth           int          `json:"Depth"`
+	Headers         []ForkHeader `json:"Headers"`
+	base            int          // depth of the fork point when splitting off another segment
 }
 
-// FindReorgs - Go through "Header" table and look for entries with the same block number but different hashes
-func (c *NodeClient) FindReorgs(ctx context.Context, writer http.ResponseWriter) (Reorg, error) {
+// FindReorgs - Go through "Header" table and look for entries with the same block number but different hashes,
+// grouping the headers which CanonicalHeader did not select into fork segments.
+// If blocks is non-zero only that many of the most recent blocks are scanned
+func (c *NodeClient) FindReorgs(ctx context.Context, writer http.ResponseWriter, blocks uint64) (Reorg, error) {
+	reorg, err := c.ScanReorgs(ctx, blocks, nil)
+
+	if err != nil {
+		fmt.Fprintf(writer, "%v", err)
+		return Reorg{}, err
+	}
+
+	for _, err := range reorg.Errors {
+		fmt.Fprintf(writer, "%v\n", err)
+	}
+
+	return reorg, nil
+}
+
+// ScanReorgs does the work of FindReorgs, reporting its progress as it goes to
+// the optional progress func. Cancelling ctx stops the scan and returns its error
+func (c *NodeClient) ScanReorgs(ctx context.Context, blocks uint64, progress ReorgProgressFunc) (Reorg, error) {
 	start := time.Now()
 	var err error
 
-	rc := NewRemoteCursor(c)
+	rc := NewRemoteCursor(c, WithChunkSize(reorgScanChunkSize), WithPrefetch(reorgScanPrefetch))
+	defer rc.Close()
+
 	if err = rc.Init(ctx, headersDb, headersTable, nil); err != nil {
-		fmt.Fprintf(writer, "Create remote cursor: %v", err)
-		return Reorg{}, err
+		return Reorg{}, fmt.Errorf("create remote cursor: %w", err)
 	}
 
-	total, wrongBlocks, errors := c.findReorgsInternally(ctx, rc)
-	for _, err := range errors {
-		if err != nil {
-			fmt.Fprintf(writer, "%v\n", err)
+	var from uint64
+
+	if blocks > 0 {
+		if from, err = limitToRecentBlocks(ctx, rc, blocks); err != nil {
+			return Reorg{}, fmt.Errorf("limit remote cursor: %w", err)
 		}
 	}
 
-	return Reorg{
-		TotalScanned: len(total),
+	canonical := NewRemoteCursor(c, WithChunkSize(reorgScanChunkSize), WithPrefetch(reorgScanPrefetch))
+	defer canonical.Close()
+
+	if err = canonical.Init(ctx, headersDb, canonicalHeaderTable, binary.BigEndian.AppendUint64(nil, from)); err != nil {
+		return Reorg{}, fmt.Errorf("create remote cursor: %w", err)
+	}
+
+	tracker := &forkTracker{canonical: canonical}
+
+	report := func(rows int, scanned int, current uint64) {
+		if progress != nil {
+			progress(ReorgProgress{
+				RowsScanned:   rows,
+				BlocksScanned: scanned,
+				CurrentBlock:  current,
+				ReorgsFound:   len(tracker.forks),
+				Elapsed:       time.Since(start).String(),
+			})
+		}
+	}
+
+	total, wrongBlocks, errors := c.findReorgsInternally(ctx, rc, tracker, report)
+
+	if ctx.Err() != nil {
+		return Reorg{}, ctx.Err()
+	}
+
+	reorg := Reorg{
+		TotalScanned: total,
 		WrongBlocks:  wrongBlocks,
+		Forks:        make([]ForkSegment, 0, len(tracker.forks)),
 		TimeTook:     time.Since(start).String(),
-	}, nil
+	}
+
+	for _, fork := range tracker.forks {
+		reorg.Forks = append(reorg.Forks, *fork)
+		reorg.MaxDepth = max(reorg.MaxDepth, fork.Depth)
+	}
+
+	for _, err := range errors {
+		if err != nil {
+			reorg.Errors = append(reorg.Errors, err.Error())
+		}
+	}
+
+	return reorg, nil
+}
+
+// limitToRecentBlocks restricts the cursor to the Header rows of the given number of
+// blocks leading up to and including the latest one, returning the first block number
+// in range
+func limitToRecentBlocks(ctx context.Context, rc RemoteDbReader, blocks uint64) (uint64, error) {
+	k, _, err := rc.Last(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("reading last %s: %w", headersTable, err)
+	}
+
+	if k == nil {
+		return 0, nil
+	}
+
+	latest, err := DecodeBlockKey(k)
+
+	if err != nil {
+		return 0, fmt.Errorf("decoding last %s key: %w", headersTable, err)
+	}
+
+	var from uint64
+
+	if latest.Number >= blocks {
+		from = latest.Number - blocks + 1
+	}
+
+	return from, rc.Range(ctx, binary.BigEndian.AppendUint64(nil, from), nil)
 }
 
 /*func (c *NodeClient) executeFlush(writer io.Writer,
@@ -60,22 +194,39 @@ func (c *NodeClient) FindReorgs(ctx context.Context, writer http.ResponseWriter)
 }*/
 
 // findReorgsInternally - searching for reorgs,
-// return back total blocks set and wrong blocks
+// return back the number of blocks scanned and wrong blocks,
+// passing the headers of each block to the fork tracker and
+// reporting progress every maxCount rows.
 // if there are errors in the middle of processing will return back
 // slice of errors
-func (c *NodeClient) findReorgsInternally(ctx context.Context, rc *RemoteCursor) (map[uint64][]byte, []uint64, []error) {
+func (c *NodeClient) findReorgsInternally(ctx context.Context, rc RemoteDbReader, tracker *forkTracker, report func(rows int, blocks int, current uint64)) (int, []uint64, []error) {
 	var errors []error
-	set := make(map[uint64][]byte)
 	var wrongBlocks []uint64
+	var total int
 
-	var k []byte
+	var height uint64
+	var headers []KeyValue
+
+	addHeight := func() {
+		if len(headers) == 0 {
+			return
+		}
+
+		if err := tracker.addHeight(ctx, height, headers); err != nil {
+			errors = append(errors, err)
+		}
+
+		headers = headers[:0]
+	}
+
+	var k, v []byte
 
 	var iterator int
 	var err error
-	for k, _, err = rc.Next(ctx); err == nil && k != nil; k, _, err = rc.Next(ctx) {
+	for k, v, err = rc.Next(ctx); err == nil && k != nil; k, v, err = rc.Next(ctx) {
 		select {
 		case <-ctx.Done():
-			return nil, nil, []error{fmt.Errorf("Interrupted")}
+			return 0, nil, []error{fmt.Errorf("Interrupted")}
 		default:
 		}
 
@@ -83,31 +234,161 @@ func (c *NodeClient) findReorgsInternally(ctx context.Context, rc *RemoteCursor)
 			continue
 		}
 
-		bn := binary.BigEndian.Uint64(k[:8])
-		_, found := set[bn]
-		if found {
-			//if template != nil {
-			//	if err := c.executeFlush(nil, template, "reorg_block.html", bn); err != nil {
-			//		errors = append(errors, fmt.Errorf("Executing reorg_block template: %v\n", err))
-			//	}
-			//}
+		blockKey, err := DecodeBlockKey(k)
+
+		if err != nil {
+			errors = append(errors, fmt.Errorf("decoding %s key: %w", headersTable, err))
+			continue
+		}
+
+		bn := blockKey.Number
+
+		if len(headers) > 0 && bn == height {
 			wrongBlocks = append(wrongBlocks, bn)
+		} else {
+			addHeight()
+			height = bn
+			total++
 		}
-		set[bn] = k
+
+		headers = append(headers, KeyValue{Key: k, Value: v})
 
 		iterator++
 		if iterator%maxCount == 0 {
-			fmt.Print(".")
-			//if template != nil {
-			//	if err := c.executeFlush(nil, template, "reorg_block.html", bn); err != nil {
-			//		errors = append(errors, fmt.Errorf("Executing reorg_spacer template: %v\n", err))
-			//	}
-			//}
+			report(iterator, total, bn)
 		}
 	}
 	if err != nil {
 		errors = append(errors, err)
 	}
 
-	return set, wrongBlocks, errors
+	addHeight()
+	report(iterator, total, height)
+
+	return total, wrongBlocks, errors
+}
+
+// forkTracker groups the non-canonical headers of consecutive block heights into
+// fork segments, reading the CanonicalHeader table alongside the Header table
+type forkTracker struct {
+	canonical       RemoteDbReader
+	canonicalNumber uint64
+	canonicalHash   []byte // hash of the CanonicalHeader row at canonicalNumber, nil once exhausted
+	canonicalRead   bool
+	started         bool
+	lastHeight      uint64
+	lastCanonical   []byte                  // canonical hash at lastHeight
+	open            map[string]*ForkSegment // segments ending at lastHeight, keyed by their tip hash
+	forks           []*ForkSegment
+}
+
+// canonicalAt returns the canonical hash of the given block number, or nil when it has none.
+// Block numbers must be asked for in ascending order
+func (t *forkTracker) canonicalAt(ctx context.Context, number uint64) ([]byte, error) {
+	for !t.canonicalRead || (t.canonicalHash != nil && t.canonicalNumber < number) {
+		t.canonicalRead = true
+
+		k, v, err := t.canonical.Next(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", canonicalHeaderTable, err)
+		}
+
+		if k == nil {
+			t.canonicalHash = nil
+			break
+		}
+
+		if t.canonicalNumber, err = decodeBlockNum(k); err != nil {
+			return nil, fmt.Errorf("decoding %s key: %w", canonicalHeaderTable, err)
+		}
+
+		if t.canonicalHash, err = decodeHash(v); err != nil {
+			return nil, fmt.Errorf("decoding %s value: %w", canonicalHeaderTable, err)
+		}
+	}
+
+	if t.canonicalHash == nil || t.canonicalNumber != number {
+		return nil, nil
+	}
+
+	return t.canonicalHash, nil
+}
+
+// addHeight records the non-canonical ones among the headers at the given height,
+// extending the segments which ended with their parents at the previous height
+func (t *forkTracker) addHeight(ctx context.Context, number uint64, headers []KeyValue) error {
+	canonicalHash, err := t.canonicalAt(ctx, number)
+
+	if err != nil {
+		return err
+	}
+
+	contiguous := t.started && number == t.lastHeight+1
+	extended := map[string]*ForkSegment{}
+	claimed := map[*ForkSegment]bool{}
+
+	var errs []error
+
+	for _, header := range headers {
+		hash := HexBytes(header.Key[blockNumLength:])
+
+		// without a canonical hash there is nothing to tell which header was selected
+		if canonicalHash == nil || bytes.Equal(hash, canonicalHash) {
+			continue
+		}
+
+		decoded, err := DecodeHeader(header.Value)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("decoding %s %d: %w", headersTable, number, err))
+			continue
+		}
+
+		forkHeader := ForkHeader{
+			Number:        number,
+			Hash:          hash,
+			ParentHash:    decoded.ParentHash,
+			CanonicalHash: canonicalHash,
+		}
+
+		var segment *ForkSegment
+
+		if contiguous {
+			segment = t.open[string(decoded.ParentHash)]
+		}
+
+		if segment == nil || claimed[segment] {
+			// a new segment, either off the chain or splitting off the tip of another segment
+			split := segment
+
+			segment = &ForkSegment{
+				ForkHash:        decoded.ParentHash,
+				ForkIsCanonical: contiguous && bytes.Equal(decoded.ParentHash, t.lastCanonical),
+			}
+
+			if split != nil {
+				// the segment split off has already been extended at this height
+				segment.base = split.Depth - 1
+			}
+
+			if number > 0 {
+				segment.ForkPoint = number - 1
+			}
+
+			t.forks = append(t.forks, segment)
+		}
+
+		claimed[segment] = true
+		segment.Headers = append(segment.Headers, forkHeader)
+		segment.Depth = segment.base + len(segment.Headers)
+		extended[string(hash)] = segment
+	}
+
+	t.started = true
+	t.lastHeight = number
+	t.lastCanonical = canonicalHash
+	t.open = extended
+
+	return errors.Join(errs...)
 }
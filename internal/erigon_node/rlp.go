@@ -0,0 +1,140 @@
+package erigon_node
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// HexBytes is a byte slice which is rendered as a 0x prefixed hex string in JSON
+type HexBytes []byte
+
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte("0x" + hex.EncodeToString(b)), nil
+}
+
+// rlpItem is a decoded RLP value - either a byte string or a list of items
+type rlpItem struct {
+	isList bool
+	data   []byte
+	list   []rlpItem
+}
+
+// decodeRLP decodes a single RLP item from the start of data and returns
+// the item together with any remaining bytes
+func decodeRLP(data []byte) (rlpItem, []byte, error) {
+	if len(data) == 0 {
+		return rlpItem{}, nil, fmt.Errorf("rlp: unexpected end of input")
+	}
+
+	prefix := data[0]
+
+	switch {
+	case prefix < 0x80:
+		return rlpItem{data: data[:1]}, data[1:], nil
+	case prefix < 0xb8:
+		if prefix == 0x81 && len(data) > 1 && data[1] < 0x80 {
+			return rlpItem{}, nil, fmt.Errorf("rlp: single byte %#x must not have a size prefix", data[1])
+		}
+		return rlpString(data[1:], uint64(prefix-0x80))
+	case prefix < 0xc0:
+		size, rest, err := rlpSize(data[1:], int(prefix-0xb7))
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		return rlpString(rest, size)
+	case prefix < 0xf8:
+		return rlpList(data[1:], uint64(prefix-0xc0))
+	default:
+		size, rest, err := rlpSize(data[1:], int(prefix-0xf7))
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		return rlpList(rest, size)
+	}
+}
+
+// rlpSize reads the big endian size of a long string or list, which must be
+// canonical - without leading zeros and too big for the short form
+func rlpSize(data []byte, length int) (uint64, []byte, error) {
+	if len(data) < length {
+		return 0, nil, fmt.Errorf("rlp: size of %d bytes exceeds input", length)
+	}
+
+	if data[0] == 0 {
+		return 0, nil, fmt.Errorf("rlp: size has leading zero bytes")
+	}
+
+	var size uint64
+
+	for _, b := range data[:length] {
+		size = size<<8 | uint64(b)
+	}
+
+	if size < 56 {
+		return 0, nil, fmt.Errorf("rlp: size %d must not use the long form", size)
+	}
+
+	return size, data[length:], nil
+}
+
+func rlpString(data []byte, size uint64) (rlpItem, []byte, error) {
+	if uint64(len(data)) < size {
+		return rlpItem{}, nil, fmt.Errorf("rlp: string of %d bytes exceeds input", size)
+	}
+
+	return rlpItem{data: data[:size]}, data[size:], nil
+}
+
+func rlpList(data []byte, size uint64) (rlpItem, []byte, error) {
+	if uint64(len(data)) < size {
+		return rlpItem{}, nil, fmt.Errorf("rlp: list of %d bytes exceeds input", size)
+	}
+
+	item := rlpItem{isList: true}
+	content := data[:size]
+
+	for len(content) > 0 {
+		var child rlpItem
+		var err error
+
+		if child, content, err = decodeRLP(content); err != nil {
+			return rlpItem{}, nil, err
+		}
+
+		item.list = append(item.list, child)
+	}
+
+	return item, data[size:], nil
+}
+
+func (i rlpItem) bytes() (HexBytes, error) {
+	if i.isList {
+		return nil, fmt.Errorf("rlp: expected string, got list")
+	}
+
+	return i.data, nil
+}
+
+func (i rlpItem) uint64() (uint64, error) {
+	if i.isList {
+		return 0, fmt.Errorf("rlp: expected integer, got list")
+	}
+
+	if len(i.data) > 8 {
+		return 0, fmt.Errorf("rlp: integer of %d bytes overflows uint64", len(i.data))
+	}
+
+	var buf [8]byte
+	copy(buf[8-len(i.data):], i.data)
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (i rlpItem) bigInt() (*big.Int, error) {
+	if i.isList {
+		return nil, fmt.Errorf("rlp: expected integer, got list")
+	}
+
+	return new(big.Int).SetBytes(i.data), nil
+}
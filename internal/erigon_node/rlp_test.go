@@ -0,0 +1,141 @@
+package erigon_node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describe renders an item as nested [] lists of %q strings
+func describe(item rlpItem) string {
+	if !item.isList {
+		return fmt.Sprintf("%q", item.data)
+	}
+
+	children := make([]string, len(item.list))
+
+	for i, child := range item.list {
+		children[i] = describe(child)
+	}
+
+	return "[" + strings.Join(children, " ") + "]"
+}
+
+func TestDecodeRLP(t *testing.T) {
+	a55 := strings.Repeat("61", 55)
+	a56 := strings.Repeat("61", 56)
+	a1024 := strings.Repeat("61", 1024)
+
+	for _, tt := range []struct {
+		name     string
+		input    string
+		want     string
+		wantRest string
+		wantErr  bool
+	}{
+		{name: "single byte", input: "00", want: `"\x00"`},
+		{name: "highest single byte", input: "7f", want: `"\x7f"`},
+		{name: "empty string", input: "80", want: `""`},
+		{name: "byte with prefix", input: "8180", want: `"\x80"`},
+		{name: "short string", input: "83646f67", want: `"dog"`},
+		{name: "longest short string", input: "b7" + a55, want: fmt.Sprintf("%q", strings.Repeat("a", 55))},
+		{name: "long string", input: "b838" + a56, want: fmt.Sprintf("%q", strings.Repeat("a", 56))},
+		{name: "long string of two size bytes", input: "b90400" + a1024, want: fmt.Sprintf("%q", strings.Repeat("a", 1024))},
+		{name: "empty list", input: "c0", want: "[]"},
+		{name: "short list", input: "c88363617483646f67", want: `["cat" "dog"]`},
+		{name: "nested lists", input: "c7c0c1c0c3c0c1c0", want: "[[] [[]] [[] [[]]]]"},
+		{name: "long list", input: "f838" + strings.Repeat("83616263", 14), want: "[" + strings.TrimSpace(strings.Repeat(`"abc" `, 14)) + "]"},
+		{name: "remaining bytes", input: "83646f67c0ff", want: `"dog"`, wantRest: "c0ff"},
+		{name: "empty input", input: "", wantErr: true},
+		{name: "truncated short string", input: "83646f", wantErr: true},
+		{name: "truncated long string", input: "b838" + a55, wantErr: true},
+		{name: "truncated size", input: "b904", wantErr: true},
+		{name: "truncated list", input: "c8836361748364", wantErr: true},
+		{name: "item overrunning its list", input: "c383636174", wantErr: true},
+		{name: "truncated long list", input: "f838" + strings.Repeat("83616263", 13), wantErr: true},
+		{name: "single byte with a prefix", input: "8105", wantErr: true},
+		{name: "short string in the long form", input: "b803646f67", wantErr: true},
+		{name: "size with a leading zero", input: "b90038" + a56, wantErr: true},
+		{name: "short list in the long form", input: "f803c0c0c0", wantErr: true},
+		{name: "list size with a leading zero", input: "f90038" + strings.Repeat("83616263", 14), wantErr: true},
+		{name: "non-canonical item in a list", input: "c28105", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := hex.DecodeString(tt.input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			item, rest, err := decodeRLP(input)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %s, want an error", describe(item))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := describe(item); got != tt.want {
+				t.Fatalf("decoded %s, want %s", got, tt.want)
+			}
+
+			if got := hex.EncodeToString(rest); got != tt.wantRest {
+				t.Fatalf("remaining bytes %s, want %s", got, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestRLPItemValues(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		input      string
+		wantUint64 uint64
+		wantBigInt string
+		wantErr    bool
+	}{
+		{name: "zero", input: "80", wantUint64: 0, wantBigInt: "0"},
+		{name: "single byte", input: "0f", wantUint64: 15, wantBigInt: "15"},
+		{name: "two bytes", input: "821388", wantUint64: 5000, wantBigInt: "5000"},
+		{name: "eight bytes", input: "88ffffffffffffffff", wantUint64: 1<<64 - 1, wantBigInt: "18446744073709551615"},
+		{name: "nine bytes", input: "89010000000000000000", wantErr: true, wantBigInt: "18446744073709551616"},
+		{name: "list", input: "c0", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := hex.DecodeString(tt.input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			item, _, err := decodeRLP(input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, err := item.uint64()
+
+			if tt.wantErr != (err != nil) || value != tt.wantUint64 {
+				t.Fatalf("uint64 got %d, %v, want %d", value, err, tt.wantUint64)
+			}
+
+			if item.isList {
+				if _, err := item.bigInt(); err == nil {
+					t.Fatal("bigInt of a list got no error")
+				}
+				return
+			}
+
+			if bigInt, err := item.bigInt(); err != nil || bigInt.String() != tt.wantBigInt {
+				t.Fatalf("bigInt got %v, %v, want %s", bigInt, err, tt.wantBigInt)
+			}
+		})
+	}
+}
@@ -2,52 +2,256 @@ package erigon_node
 
 import (
 	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func (c *NodeClient) Subscribe(ctx context.Context, channel chan []byte, service string) error {
-	request, err := c.fetch(ctx, "subscribe/"+service, nil)
+const (
+	resubscribeDelay    = time.Second
+	resubscribeDelayMax = 30 * time.Second
+	unsubscribeTimeout  = 10 * time.Second
+)
 
-	if err != nil {
-		return err
+// SubscriptionHub holds one subscription to each service of a node, however many subscribers
+// share it, so that subscribers do not multiply the load on the node. The subscription is made
+// for the first subscriber and ended after the last, and is made again if it ends while it still
+// has subscribers, such as when the node reconnects to the bridge
+type SubscriptionHub struct {
+	client   *NodeClient
+	lock     sync.Mutex
+	services map[string]*serviceSubscription
+	stopping map[string]chan struct{} // closed once the ended subscription to the service has been unsubscribed
+}
+
+type serviceSubscription struct {
+	subscribers map[*Subscription]struct{}
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// Subscription is a subscriber's share of the subscription to a service
+type Subscription struct {
+	hub     *SubscriptionHub
+	service string
+	channel chan<- []byte
+	dropped atomic.Uint64 // messages dropped while channel was full, since TakeDropped
+	once    sync.Once
+	done    chan struct{}
+}
+
+func newSubscriptionHub(client *NodeClient) *SubscriptionHub {
+	return &SubscriptionHub{
+		client:   client,
+		services: map[string]*serviceSubscription{},
+		stopping: map[string]chan struct{}{},
+	}
+}
+
+// Subscribe passes the messages of the service to channel until the subscription is unsubscribed,
+// after which channel may be closed. Messages are dropped while channel is full, rather than hold
+// up the other subscribers, so it should be buffered. TakeDropped counts the messages dropped
+func (h *SubscriptionHub) Subscribe(service string, channel chan<- []byte) *Subscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	subscription := &Subscription{hub: h, service: service, channel: channel, done: make(chan struct{})}
+	shared, ok := h.services[service]
+
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		shared = &serviceSubscription{
+			subscribers: map[*Subscription]struct{}{},
+			cancel:      cancel,
+			done:        make(chan struct{}),
+		}
+
+		h.services[service] = shared
+		go h.run(ctx, service, shared, h.stopping[service])
+	}
+
+	shared.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Subscribers returns the number of subscribers sharing the subscription to the service
+func (h *SubscriptionHub) Subscribers(service string) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if shared, ok := h.services[service]; ok {
+		return len(shared.subscribers)
 	}
 
+	return 0
+}
+
+// Unsubscribe stops passing messages to the subscriber, the subscription
+// to the service ends when it has no subscribers left
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.hub.unsubscribe(s)
+		close(s.done)
+	})
+}
+
+// TakeDropped returns the number of messages dropped because the subscriber's
+// channel was full since it was last called
+func (s *Subscription) TakeDropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Done is closed once the subscriber has unsubscribed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (h *SubscriptionHub) unsubscribe(subscription *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	shared, ok := h.services[subscription.service]
+
+	if !ok {
+		return
+	}
+
+	delete(shared.subscribers, subscription)
+
+	if len(shared.subscribers) == 0 {
+		delete(h.services, subscription.service)
+		h.stopping[subscription.service] = shared.done
+		shared.cancel()
+	}
+}
+
+func (h *SubscriptionHub) publish(shared *serviceSubscription, message []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for subscriber := range shared.subscribers {
+		select {
+		case subscriber.channel <- message:
+		default:
+			subscriber.dropped.Add(1)
+		}
+	}
+}
+
+// run keeps the node subscribed to the service until ctx is cancelled, once the previous
+// subscription to the service, if any, has been unsubscribed
+func (h *SubscriptionHub) run(ctx context.Context, service string, shared *serviceSubscription, previous <-chan struct{}) {
+	defer func() {
+		h.lock.Lock()
+		if h.stopping[service] == shared.done {
+			delete(h.stopping, service)
+		}
+		h.lock.Unlock()
+
+		close(shared.done)
+	}()
+
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	delay := resubscribeDelay
+
 	for {
-		more, result, err := request.nextResult(ctx)
+		streamed, err := h.stream(ctx, service, shared)
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if streamed {
+			delay = resubscribeDelay
+		}
+
+		if errors.Is(err, ErrNodeDisconnected) {
+			// resubscribe as soon as the node reconnects
+			if h.client.waitConnected(ctx) != nil {
+				break
+			}
+
+			continue
+		}
 
 		if err != nil {
-			return err
+			log.Printf("Subscription to %s of node %s failed: %v\n", service, h.client.nodeId, err)
 		}
 
-		channel <- result
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
 
-		if !more {
+		if ctx.Err() != nil {
 			break
 		}
+
+		delay = min(2*delay, resubscribeDelayMax)
 	}
 
-	return nil
+	h.unsubscribeNode(service)
 }
 
-func (c *NodeClient) Unsubscribe(ctx context.Context, channel chan []byte, service string) error {
-	request, err := c.fetch(ctx, "unsubscribe/"+service, nil)
+// stream passes the messages of one subscription to the subscribers, until it
+// ends, returning true if the node sent any
+func (h *SubscriptionHub) stream(ctx context.Context, service string, shared *serviceSubscription) (bool, error) {
+	request, err := h.client.fetch(ctx, "subscribe/"+service, nil)
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
+	var streamed bool
+
 	for {
 		more, result, err := request.nextResult(ctx)
 
 		if err != nil {
-			return err
+			return streamed, err
 		}
 
-		channel <- result
+		streamed = true
+		h.publish(shared, result)
 
 		if !more {
-			break
+			return streamed, nil
 		}
 	}
+}
+
+// unsubscribeNode asks the node to end the subscription, nodes which understand
+// cancellations have already ended it with the subscription's request
+func (h *SubscriptionHub) unsubscribeNode(service string) {
+	if !h.client.Supports("unsubscribe/"+service) || !h.client.isConnected() {
+		return
+	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), unsubscribeTimeout)
+	defer cancel()
+
+	request, err := h.client.fetch(ctx, "unsubscribe/"+service, nil)
+
+	if err != nil {
+		log.Printf("Unsubscribing from %s of node %s failed: %v\n", service, h.client.nodeId, err)
+		return
+	}
+
+	for {
+		more, _, err := request.nextResult(ctx)
+
+		if err != nil || !more {
+			return
+		}
+	}
 }
@@ -0,0 +1,38 @@
+package erigon_node
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSubscriptionHubDrops(t *testing.T) {
+	hub := &SubscriptionHub{}
+	shared := &serviceSubscription{subscribers: map[*Subscription]struct{}{}}
+
+	slow := make(chan []byte, 2)
+	fast := make(chan []byte, 10)
+	slowSubscription := &Subscription{hub: hub, channel: slow}
+	fastSubscription := &Subscription{hub: hub, channel: fast}
+	shared.subscribers[slowSubscription] = struct{}{}
+	shared.subscribers[fastSubscription] = struct{}{}
+
+	for i := 0; i < 5; i++ {
+		hub.publish(shared, []byte(strconv.Itoa(i)))
+	}
+
+	// the slow subscriber is told of the messages it missed, without holding up the other
+	if dropped := slowSubscription.TakeDropped(); dropped != 3 || len(slow) != 2 {
+		t.Fatalf("slow subscriber dropped %d and holds %d messages", dropped, len(slow))
+	}
+
+	if dropped := fastSubscription.TakeDropped(); dropped != 0 || len(fast) != 5 {
+		t.Fatalf("fast subscriber dropped %d and holds %d messages", dropped, len(fast))
+	}
+
+	<-slow
+	hub.publish(shared, []byte("5"))
+
+	if dropped := slowSubscription.TakeDropped(); dropped != 0 {
+		t.Fatalf("drops were counted twice, or a message with room was dropped: %d", dropped)
+	}
+}
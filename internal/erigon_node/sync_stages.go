@@ -2,7 +2,6 @@ package erigon_node
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"strconv"
 )
@@ -27,6 +26,8 @@ func (c *NodeClient) FindSyncStages(ctx context.Context) (SyncStageProgress, err
 }
 
 func (ss *SyncStages) fetchSyncStageProgress(ctx context.Context) (SyncStageProgress, error) {
+	defer ss.rc.Close()
+
 	if cursorError := ss.rc.Init(ctx, syncStageDb, syncStageTable, nil); cursorError != nil {
 		return nil, fmt.Errorf("could not initialize remote cursor: %w", cursorError)
 	}
@@ -42,13 +43,19 @@ func (ss *SyncStages) fetchSyncStageProgress(ctx context.Context) (SyncStageProg
 		default:
 		}
 
-		syncStage := string(k)
-		syncProgress, unmarshalError := ss.unmarshal(v)
+		row, unmarshalError := ss.decode(k, v)
 
 		if unmarshalError != nil {
 			return nil, fmt.Errorf("could not unmarshal sync stage data: %w", unmarshalError)
 		}
 
+		syncStage, stageOk := row.Key.(string)
+		syncProgress, progressOk := row.Value.(uint64)
+
+		if !stageOk || !progressOk {
+			return nil, fmt.Errorf("unexpected sync stage row types: %T, %T", row.Key, row.Value)
+		}
+
 		syncStageProgress[syncStage] = strconv.FormatUint(syncProgress, syncProgressBase)
 	}
 	if e != nil {
@@ -58,12 +65,12 @@ func (ss *SyncStages) fetchSyncStageProgress(ctx context.Context) (SyncStageProg
 	return syncStageProgress, nil
 }
 
-func (ss *SyncStages) unmarshal(data []byte) (uint64, error) {
-	if len(data) == 0 {
-		return 0, nil
-	}
-	if len(data) < 8 {
-		return 0, fmt.Errorf("value must be at least 8 bytes, got %d", len(data))
+func (ss *SyncStages) decode(key []byte, value []byte) (DecodedRow, error) {
+	decoder, ok := FindTableDecoder(syncStageDb, syncStageTable)
+
+	if !ok {
+		return DecodedRow{}, fmt.Errorf("no decoder registered for %s table", syncStageTable)
 	}
-	return binary.BigEndian.Uint64(data[:8]), nil
+
+	return decoder(key, value)
 }
@@ -0,0 +1,76 @@
+package erigon_node
+
+import (
+	"context"
+	"fmt"
+)
+
+// SysInfo describes the hardware the node runs on, with sizes in bytes
+type SysInfo struct {
+	Disk DiskInfo  `json:"disk"`
+	RAM  RAMInfo   `json:"ram"`
+	CPU  []CPUInfo `json:"cpu"`
+}
+
+// DiskInfo describes the disk holding the node's data directory
+type DiskInfo struct {
+	FsType     string `json:"fsType"`
+	Total      uint64 `json:"total"`
+	Free       uint64 `json:"free"`
+	MountPoint string `json:"mountPoint"`
+	Device     string `json:"device"`
+	Details    string `json:"details"`
+}
+
+type RAMInfo struct {
+	Total       uint64  `json:"total"`
+	Available   uint64  `json:"available"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"usedPercent"`
+}
+
+type CPUInfo struct {
+	CPU        int32    `json:"cpu"`
+	VendorId   string   `json:"vendorId"`
+	Family     string   `json:"family"`
+	Model      string   `json:"model"`
+	Stepping   int32    `json:"stepping"`
+	PhysicalId string   `json:"physicalId"`
+	CoreId     string   `json:"coreId"`
+	Cores      int32    `json:"cores"`
+	ModelName  string   `json:"modelName"`
+	Mhz        float64  `json:"mhz"`
+	CacheSize  int32    `json:"cacheSize"`
+	Flags      []string `json:"flags"`
+	Microcode  string   `json:"microcode"`
+}
+
+// Validate requires the free and used sizes to be within the totals
+func (s SysInfo) Validate() error {
+	if s.Disk.Free > s.Disk.Total {
+		return fmt.Errorf("disk has %d bytes free of %d", s.Disk.Free, s.Disk.Total)
+	}
+
+	if s.RAM.Available > s.RAM.Total || s.RAM.Used > s.RAM.Total {
+		return fmt.Errorf("RAM has %d bytes available and %d used of %d", s.RAM.Available, s.RAM.Used, s.RAM.Total)
+	}
+
+	if s.RAM.UsedPercent < 0 || s.RAM.UsedPercent > 100 {
+		return fmt.Errorf("RAM is %v%% used", s.RAM.UsedPercent)
+	}
+
+	for _, cpu := range s.CPU {
+		if cpu.Cores < 0 || cpu.Mhz < 0 {
+			return fmt.Errorf("CPU %d has %d cores at %v MHz", cpu.CPU, cpu.Cores, cpu.Mhz)
+		}
+	}
+
+	return nil
+}
+
+// SysInfo returns the CPU, memory and disk of the node's host
+func (c *NodeClient) SysInfo(ctx context.Context) (SysInfo, error) {
+	var info SysInfo
+	err := c.fetchData(ctx, "hardware-info", &info)
+	return info, err
+}
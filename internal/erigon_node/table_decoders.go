@@ -0,0 +1,482 @@
+package erigon_node
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// Layouts of the well-known Erigon chaindata tables which can be decoded
+// from the raw key/value pairs returned by the remote cursor
+const (
+	canonicalHeaderTable = "CanonicalHeader"
+	headerNumberTable    = "HeaderNumber"
+	blockBodyTable       = "BlockBody"
+	plainStateTable      = "PlainState"
+	txLookupTable        = "TxLookup"
+
+	blockNumLength    = 8
+	hashLength        = 32
+	addressLength     = 20
+	incarnationLength = 8
+)
+
+// DecodedRow is the typed representation of a raw table row, ready to be rendered as JSON
+type DecodedRow struct {
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// TableDecoder turns the raw key and value of a table row into a DecodedRow
+type TableDecoder func(key []byte, value []byte) (DecodedRow, error)
+
+type tableDecoderId struct {
+	db    string
+	table string
+}
+
+var (
+	tableDecodersLock sync.RWMutex
+	tableDecoders     = map[tableDecoderId]TableDecoder{}
+)
+
+// RegisterTableDecoder sets the decoder used for rows of the given db table,
+// replacing any previously registered one
+func RegisterTableDecoder(db string, table string, decoder TableDecoder) {
+	tableDecodersLock.Lock()
+	defer tableDecodersLock.Unlock()
+	tableDecoders[tableDecoderId{db, table}] = decoder
+}
+
+// FindTableDecoder returns the decoder registered for the given db table
+func FindTableDecoder(db string, table string) (TableDecoder, bool) {
+	tableDecodersLock.RLock()
+	defer tableDecodersLock.RUnlock()
+	decoder, ok := tableDecoders[tableDecoderId{db, table}]
+	return decoder, ok
+}
+
+func init() {
+	RegisterTableDecoder(headersDb, headersTable, decodeHeaderRow)
+	RegisterTableDecoder(headersDb, canonicalHeaderTable, decodeCanonicalHeaderRow)
+	RegisterTableDecoder(headersDb, headerNumberTable, decodeHeaderNumberRow)
+	RegisterTableDecoder(headersDb, blockBodyTable, decodeBlockBodyRow)
+	RegisterTableDecoder(syncStageDb, syncStageTable, decodeSyncStageRow)
+	RegisterTableDecoder(headersDb, plainStateTable, decodePlainStateRow)
+	RegisterTableDecoder(headersDb, txLookupTable, decodeTxLookupRow)
+}
+
+// BlockKey is the block number + block hash key used by the Header and BlockBody tables
+type BlockKey struct {
+	Number uint64   `json:"number"`
+	Hash   HexBytes `json:"hash"`
+}
+
+func DecodeBlockKey(key []byte) (BlockKey, error) {
+	if len(key) != blockNumLength+hashLength {
+		return BlockKey{}, fmt.Errorf("block key must be %d bytes, got %d", blockNumLength+hashLength, len(key))
+	}
+
+	return BlockKey{
+		Number: binary.BigEndian.Uint64(key[:blockNumLength]),
+		Hash:   key[blockNumLength:],
+	}, nil
+}
+
+func decodeBlockNum(data []byte) (uint64, error) {
+	if len(data) != blockNumLength {
+		return 0, fmt.Errorf("block number must be %d bytes, got %d", blockNumLength, len(data))
+	}
+
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func decodeHash(data []byte) (HexBytes, error) {
+	if len(data) != hashLength {
+		return nil, fmt.Errorf("hash must be %d bytes, got %d", hashLength, len(data))
+	}
+
+	return data, nil
+}
+
+// Header holds the fields of an RLP encoded block header. Fields introduced by
+// later forks are only set when present in the encoding
+type Header struct {
+	ParentHash            HexBytes `json:"parentHash"`
+	UncleHash             HexBytes `json:"sha3Uncles"`
+	Coinbase              HexBytes `json:"miner"`
+	Root                  HexBytes `json:"stateRoot"`
+	TxHash                HexBytes `json:"transactionsRoot"`
+	ReceiptHash           HexBytes `json:"receiptsRoot"`
+	Bloom                 HexBytes `json:"logsBloom"`
+	Difficulty            *big.Int `json:"difficulty"`
+	Number                uint64   `json:"number"`
+	GasLimit              uint64   `json:"gasLimit"`
+	GasUsed               uint64   `json:"gasUsed"`
+	Time                  uint64   `json:"timestamp"`
+	Extra                 HexBytes `json:"extraData"`
+	MixDigest             HexBytes `json:"mixHash"`
+	Nonce                 HexBytes `json:"nonce"`
+	BaseFee               *big.Int `json:"baseFeePerGas,omitempty"`
+	WithdrawalsHash       HexBytes `json:"withdrawalsRoot,omitempty"`
+	BlobGasUsed           *uint64  `json:"blobGasUsed,omitempty"`
+	ExcessBlobGas         *uint64  `json:"excessBlobGas,omitempty"`
+	ParentBeaconBlockRoot HexBytes `json:"parentBeaconBlockRoot,omitempty"`
+	RequestsHash          HexBytes `json:"requestsHash,omitempty"`
+}
+
+func DecodeHeader(data []byte) (*Header, error) {
+	item, _, err := decodeRLP(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return headerFromRLP(item)
+}
+
+func headerFromRLP(item rlpItem) (*Header, error) {
+	if !item.isList || len(item.list) < 15 {
+		return nil, fmt.Errorf("header must be an rlp list of at least 15 fields")
+	}
+
+	var header Header
+	var err error
+
+	fields := item.list
+
+	for i, field := range []*HexBytes{
+		&header.ParentHash, &header.UncleHash, &header.Coinbase, &header.Root,
+		&header.TxHash, &header.ReceiptHash, &header.Bloom,
+	} {
+		if *field, err = fields[i].bytes(); err != nil {
+			return nil, fmt.Errorf("header field %d: %w", i, err)
+		}
+	}
+
+	if header.Difficulty, err = fields[7].bigInt(); err != nil {
+		return nil, fmt.Errorf("header difficulty: %w", err)
+	}
+
+	for i, field := range []*uint64{&header.Number, &header.GasLimit, &header.GasUsed, &header.Time} {
+		if *field, err = fields[8+i].uint64(); err != nil {
+			return nil, fmt.Errorf("header field %d: %w", 8+i, err)
+		}
+	}
+
+	for i, field := range []*HexBytes{&header.Extra, &header.MixDigest, &header.Nonce} {
+		if *field, err = fields[12+i].bytes(); err != nil {
+			return nil, fmt.Errorf("header field %d: %w", 12+i, err)
+		}
+	}
+
+	optional := fields[15:]
+
+	if len(optional) > 0 {
+		if header.BaseFee, err = optional[0].bigInt(); err != nil {
+			return nil, fmt.Errorf("header base fee: %w", err)
+		}
+	}
+
+	if len(optional) > 1 {
+		if header.WithdrawalsHash, err = optional[1].bytes(); err != nil {
+			return nil, fmt.Errorf("header withdrawals hash: %w", err)
+		}
+	}
+
+	for i, field := range []**uint64{&header.BlobGasUsed, &header.ExcessBlobGas} {
+		if len(optional) > 2+i {
+			value, err := optional[2+i].uint64()
+
+			if err != nil {
+				return nil, fmt.Errorf("header blob gas: %w", err)
+			}
+
+			*field = &value
+		}
+	}
+
+	if len(optional) > 4 {
+		if header.ParentBeaconBlockRoot, err = optional[4].bytes(); err != nil {
+			return nil, fmt.Errorf("header parent beacon block root: %w", err)
+		}
+	}
+
+	if len(optional) > 5 {
+		if header.RequestsHash, err = optional[5].bytes(); err != nil {
+			return nil, fmt.Errorf("header requests hash: %w", err)
+		}
+	}
+
+	return &header, nil
+}
+
+func decodeHeaderRow(key []byte, value []byte) (DecodedRow, error) {
+	blockKey, err := DecodeBlockKey(key)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	header, err := DecodeHeader(value)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	return DecodedRow{Key: blockKey, Value: header}, nil
+}
+
+func decodeCanonicalHeaderRow(key []byte, value []byte) (DecodedRow, error) {
+	number, err := decodeBlockNum(key)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	hash, err := decodeHash(value)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	return DecodedRow{Key: number, Value: hash}, nil
+}
+
+func decodeHeaderNumberRow(key []byte, value []byte) (DecodedRow, error) {
+	hash, err := decodeHash(key)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	number, err := decodeBlockNum(value)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	return DecodedRow{Key: hash, Value: number}, nil
+}
+
+// BlockBody is the storage form of a block body - transactions are kept in a
+// separate table and referenced by the id of the first one and their count
+type BlockBody struct {
+	BaseTxId        uint64    `json:"baseTxId"`
+	TxCount         uint64    `json:"txCount"`
+	Uncles          []*Header `json:"uncles"`
+	WithdrawalCount *int      `json:"withdrawalCount,omitempty"`
+}
+
+func DecodeBlockBody(data []byte) (*BlockBody, error) {
+	item, _, err := decodeRLP(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !item.isList || len(item.list) < 3 {
+		return nil, fmt.Errorf("block body must be an rlp list of at least 3 fields")
+	}
+
+	var body BlockBody
+
+	if body.BaseTxId, err = item.list[0].uint64(); err != nil {
+		return nil, fmt.Errorf("block body base tx id: %w", err)
+	}
+
+	if body.TxCount, err = item.list[1].uint64(); err != nil {
+		return nil, fmt.Errorf("block body tx count: %w", err)
+	}
+
+	if !item.list[2].isList {
+		return nil, fmt.Errorf("block body uncles must be an rlp list")
+	}
+
+	body.Uncles = []*Header{}
+
+	for _, uncle := range item.list[2].list {
+		header, err := headerFromRLP(uncle)
+
+		if err != nil {
+			return nil, fmt.Errorf("block body uncle: %w", err)
+		}
+
+		body.Uncles = append(body.Uncles, header)
+	}
+
+	if len(item.list) > 3 && item.list[3].isList {
+		count := len(item.list[3].list)
+		body.WithdrawalCount = &count
+	}
+
+	return &body, nil
+}
+
+func decodeBlockBodyRow(key []byte, value []byte) (DecodedRow, error) {
+	blockKey, err := DecodeBlockKey(key)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	body, err := DecodeBlockBody(value)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	return DecodedRow{Key: blockKey, Value: body}, nil
+}
+
+// DecodeSyncStageProgress decodes the block number a sync stage has progressed to
+func DecodeSyncStageProgress(data []byte) (uint64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if len(data) < 8 {
+		return 0, fmt.Errorf("value must be at least 8 bytes, got %d", len(data))
+	}
+	return binary.BigEndian.Uint64(data[:8]), nil
+}
+
+func decodeSyncStageRow(key []byte, value []byte) (DecodedRow, error) {
+	progress, err := DecodeSyncStageProgress(value)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	return DecodedRow{Key: string(key), Value: progress}, nil
+}
+
+// Account is the storage form of an account in the PlainState table
+type Account struct {
+	Nonce       uint64   `json:"nonce"`
+	Balance     *big.Int `json:"balance"`
+	Incarnation uint64   `json:"incarnation"`
+	CodeHash    HexBytes `json:"codeHash,omitempty"`
+}
+
+// DecodeAccount decodes an account stored as a field set bitmap followed by
+// length prefixed nonce, balance, incarnation and code hash fields
+func DecodeAccount(data []byte) (*Account, error) {
+	account := Account{Balance: new(big.Int)}
+
+	if len(data) == 0 {
+		return &account, nil
+	}
+
+	fieldSet := data[0]
+	pos := 1
+
+	next := func(name string) ([]byte, error) {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("account %s length missing", name)
+		}
+
+		length := int(data[pos])
+		pos++
+
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("account %s of %d bytes exceeds value", name, length)
+		}
+
+		field := data[pos : pos+length]
+		pos += length
+		return field, nil
+	}
+
+	toUint64 := func(name string, field []byte) (uint64, error) {
+		if len(field) > 8 {
+			return 0, fmt.Errorf("account %s of %d bytes overflows uint64", name, len(field))
+		}
+
+		return new(big.Int).SetBytes(field).Uint64(), nil
+	}
+
+	if fieldSet&1 > 0 {
+		field, err := next("nonce")
+		if err != nil {
+			return nil, err
+		}
+		if account.Nonce, err = toUint64("nonce", field); err != nil {
+			return nil, err
+		}
+	}
+
+	if fieldSet&2 > 0 {
+		field, err := next("balance")
+		if err != nil {
+			return nil, err
+		}
+		account.Balance.SetBytes(field)
+	}
+
+	if fieldSet&4 > 0 {
+		field, err := next("incarnation")
+		if err != nil {
+			return nil, err
+		}
+		if account.Incarnation, err = toUint64("incarnation", field); err != nil {
+			return nil, err
+		}
+	}
+
+	if fieldSet&8 > 0 {
+		field, err := next("code hash")
+		if err != nil {
+			return nil, err
+		}
+		account.CodeHash = field
+	}
+
+	return &account, nil
+}
+
+// StorageKey is the address + incarnation + location key of a PlainState storage slot
+type StorageKey struct {
+	Address     HexBytes `json:"address"`
+	Incarnation uint64   `json:"incarnation"`
+	Location    HexBytes `json:"location"`
+}
+
+func decodePlainStateRow(key []byte, value []byte) (DecodedRow, error) {
+	switch len(key) {
+	case addressLength:
+		account, err := DecodeAccount(value)
+
+		if err != nil {
+			return DecodedRow{}, err
+		}
+
+		return DecodedRow{Key: HexBytes(key), Value: account}, nil
+	case addressLength + incarnationLength + hashLength:
+		return DecodedRow{
+			Key: StorageKey{
+				Address:     key[:addressLength],
+				Incarnation: binary.BigEndian.Uint64(key[addressLength : addressLength+incarnationLength]),
+				Location:    key[addressLength+incarnationLength:],
+			},
+			Value: HexBytes(value),
+		}, nil
+	default:
+		return DecodedRow{}, fmt.Errorf("plain state key must be %d or %d bytes, got %d",
+			addressLength, addressLength+incarnationLength+hashLength, len(key))
+	}
+}
+
+func decodeTxLookupRow(key []byte, value []byte) (DecodedRow, error) {
+	hash, err := decodeHash(key)
+
+	if err != nil {
+		return DecodedRow{}, err
+	}
+
+	if len(value) > 8 {
+		return DecodedRow{}, fmt.Errorf("tx lookup block number of %d bytes overflows uint64", len(value))
+	}
+
+	return DecodedRow{Key: hash, Value: new(big.Int).SetBytes(value).Uint64()}, nil
+}
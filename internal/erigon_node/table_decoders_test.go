@@ -0,0 +1,407 @@
+package erigon_node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// the RLP encoding of the mainnet genesis header, whose keccak256 hash is
+// d4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3
+var genesisHeader = mustDecodeHex("f90214" +
+	"a0" + strings.Repeat("00", 32) + // parent hash
+	"a01dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347" + // uncle hash
+	"94" + strings.Repeat("00", 20) + // coinbase
+	"a0d7f8974fb5ac78d9ac099b9ad5018bedc2ce0a72dad1827a1709da30580f0544" + // state root
+	"a056e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421" + // transactions root
+	"a056e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421" + // receipts root
+	"b90100" + strings.Repeat("00", 256) + // bloom
+	"850400000000" + // difficulty
+	"80" + // number
+	"821388" + // gas limit
+	"80" + // gas used
+	"80" + // time
+	"a011bbe8db4e347b4e8c937c1c8370e4b5ed33adb3db69cbdb7a38e1e50b1b82fa" + // extra
+	"a0" + strings.Repeat("00", 32) + // mix digest
+	"880000000000000042") // nonce
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// encodeString and encodeList are a minimal RLP encoder for building test values
+func encodeString(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+
+	return append(encodeSize(0x80, len(b)), b...)
+}
+
+func encodeList(items ...[]byte) []byte {
+	content := bytes.Join(items, nil)
+	return append(encodeSize(0xc0, len(content)), content...)
+}
+
+func encodeSize(offset byte, size int) []byte {
+	if size < 56 {
+		return []byte{offset + byte(size)}
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(size))
+	sizeBytes := bytes.TrimLeft(buf[:], "\x00")
+	return append([]byte{offset + 55 + byte(len(sizeBytes))}, sizeBytes...)
+}
+
+func encodeUint(n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return encodeString(bytes.TrimLeft(buf[:], "\x00"))
+}
+
+// headerFields returns the encoded fields of the genesis header, which can be
+// extended with the fields of later forks and encoded with encodeList
+func headerFields(t *testing.T) [][]byte {
+	t.Helper()
+
+	item, _, err := decodeRLP(genesisHeader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := make([][]byte, len(item.list))
+
+	for i, field := range item.list {
+		fields[i] = encodeString(field.data)
+	}
+
+	return fields
+}
+
+func TestEncodeHelpers(t *testing.T) {
+	if encoded := encodeList(headerFields(t)...); !bytes.Equal(encoded, genesisHeader) {
+		t.Fatalf("re-encoding the genesis header got %x", encoded)
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, 32)
+
+	for _, tt := range []struct {
+		name    string
+		input   func(fields [][]byte) []byte
+		check   func(t *testing.T, header *Header)
+		wantErr bool
+	}{
+		{
+			name: "genesis",
+			input: func(fields [][]byte) []byte {
+				return genesisHeader
+			},
+			check: func(t *testing.T, header *Header) {
+				got, err := json.Marshal(header)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want := `{"parentHash":"0x` + strings.Repeat("00", 32) + `",` +
+					`"sha3Uncles":"0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",` +
+					`"miner":"0x` + strings.Repeat("00", 20) + `",` +
+					`"stateRoot":"0xd7f8974fb5ac78d9ac099b9ad5018bedc2ce0a72dad1827a1709da30580f0544",` +
+					`"transactionsRoot":"0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",` +
+					`"receiptsRoot":"0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",` +
+					`"logsBloom":"0x` + strings.Repeat("00", 256) + `",` +
+					`"difficulty":17179869184,"number":0,"gasLimit":5000,"gasUsed":0,"timestamp":0,` +
+					`"extraData":"0x11bbe8db4e347b4e8c937c1c8370e4b5ed33adb3db69cbdb7a38e1e50b1b82fa",` +
+					`"mixHash":"0x` + strings.Repeat("00", 32) + `",` +
+					`"nonce":"0x0000000000000042"}`
+
+				if string(got) != want {
+					t.Fatalf("got %s\nwant %s", got, want)
+				}
+			},
+		},
+		{
+			name: "cancun",
+			input: func(fields [][]byte) []byte {
+				fields[8] = encodeUint(19000000)
+				return encodeList(append(fields, encodeUint(7), encodeString(hash), encodeUint(131072), encodeUint(0), encodeString(hash))...)
+			},
+			check: func(t *testing.T, header *Header) {
+				if header.Number != 19000000 || header.BaseFee.Uint64() != 7 || !bytes.Equal(header.WithdrawalsHash, hash) ||
+					header.BlobGasUsed == nil || *header.BlobGasUsed != 131072 || header.ExcessBlobGas == nil || *header.ExcessBlobGas != 0 ||
+					!bytes.Equal(header.ParentBeaconBlockRoot, hash) || header.RequestsHash != nil {
+					t.Fatalf("got %+v", header)
+				}
+			},
+		},
+		{
+			name: "prague",
+			input: func(fields [][]byte) []byte {
+				return encodeList(append(fields, encodeUint(7), encodeString(hash), encodeUint(0), encodeUint(0), encodeString(hash), encodeString(hash))...)
+			},
+			check: func(t *testing.T, header *Header) {
+				if !bytes.Equal(header.RequestsHash, hash) {
+					t.Fatalf("got %+v", header)
+				}
+			},
+		},
+		{
+			name: "truncated",
+			input: func(fields [][]byte) []byte {
+				return genesisHeader[:len(genesisHeader)-1]
+			},
+			wantErr: true,
+		},
+		{
+			name: "too few fields",
+			input: func(fields [][]byte) []byte {
+				return encodeList(fields[:14]...)
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a list",
+			input: func(fields [][]byte) []byte {
+				return encodeString(hash)
+			},
+			wantErr: true,
+		},
+		{
+			name: "list for a hash",
+			input: func(fields [][]byte) []byte {
+				fields[0] = encodeList()
+				return encodeList(fields...)
+			},
+			wantErr: true,
+		},
+		{
+			name: "number overflowing uint64",
+			input: func(fields [][]byte) []byte {
+				fields[8] = encodeString(bytes.Repeat([]byte{1}, 9))
+				return encodeList(fields...)
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := DecodeHeader(tt.input(headerFields(t)))
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %+v, want an error", header)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.check(t, header)
+		})
+	}
+}
+
+func TestDecodeBlockBody(t *testing.T) {
+	withdrawal := encodeList(encodeUint(1), encodeUint(2), encodeString(bytes.Repeat([]byte{1}, 20)), encodeUint(3))
+
+	for _, tt := range []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "no uncles",
+			input: encodeList(encodeUint(100), encodeUint(5), encodeList()),
+			want:  `{"baseTxId":100,"txCount":5,"uncles":[]}`,
+		},
+		{
+			name:  "withdrawals",
+			input: encodeList(encodeUint(100), encodeUint(5), encodeList(), encodeList(withdrawal, withdrawal)),
+			want:  `{"baseTxId":100,"txCount":5,"uncles":[],"withdrawalCount":2}`,
+		},
+		{
+			name:  "no withdrawals",
+			input: encodeList(encodeUint(100), encodeUint(0), encodeList(), encodeList()),
+			want:  `{"baseTxId":100,"txCount":0,"uncles":[],"withdrawalCount":0}`,
+		},
+		{
+			name:    "too few fields",
+			input:   encodeList(encodeUint(100), encodeUint(5)),
+			wantErr: true,
+		},
+		{
+			name:    "uncles not a list",
+			input:   encodeList(encodeUint(100), encodeUint(5), encodeUint(1)),
+			wantErr: true,
+		},
+		{
+			name:    "invalid uncle",
+			input:   encodeList(encodeUint(100), encodeUint(5), encodeList(encodeList(encodeUint(1)))),
+			wantErr: true,
+		},
+		{
+			name:    "list for the tx count",
+			input:   encodeList(encodeUint(100), encodeList(), encodeList()),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			input:   encodeList(encodeUint(100), encodeUint(5), encodeList())[:3],
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := DecodeBlockBody(tt.input)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %+v, want an error", body)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := json.Marshal(body); err != nil || string(got) != tt.want {
+				t.Fatalf("got %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("uncle", func(t *testing.T) {
+		body, err := DecodeBlockBody(encodeList(encodeUint(100), encodeUint(5), encodeList(genesisHeader)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Uncles) != 1 || body.Uncles[0].GasLimit != 5000 || body.WithdrawalCount != nil {
+			t.Fatalf("got %+v", body)
+		}
+	})
+}
+
+func TestTableDecoders(t *testing.T) {
+	blockKey := append(mustDecodeHex("000000000121eac0"), bytes.Repeat([]byte{0xab}, 32)...)
+	progress := mustDecodeHex("000000000121eac0")
+
+	for _, tt := range []struct {
+		name    string
+		table   string
+		key     []byte
+		value   []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "header",
+			table: "Header",
+			key:   blockKey,
+			value: genesisHeader,
+			want:  `"key":{"number":19000000,"hash":"0x` + strings.Repeat("ab", 32) + `"}`,
+		},
+		{
+			name:    "header with a short key",
+			table:   "Header",
+			key:     blockKey[:39],
+			value:   genesisHeader,
+			wantErr: true,
+		},
+		{
+			name:    "header with a truncated value",
+			table:   "Header",
+			key:     blockKey,
+			value:   genesisHeader[:100],
+			wantErr: true,
+		},
+		{
+			name:  "block body",
+			table: "BlockBody",
+			key:   blockKey,
+			value: encodeList(encodeUint(100), encodeUint(5), encodeList()),
+			want:  `"value":{"baseTxId":100,"txCount":5,"uncles":[]}`,
+		},
+		{
+			name:    "block body with a long key",
+			table:   "BlockBody",
+			key:     append(blockKey, 0),
+			value:   encodeList(encodeUint(100), encodeUint(5), encodeList()),
+			wantErr: true,
+		},
+		{
+			name:  "sync stage",
+			table: "SyncStage",
+			key:   []byte("Headers"),
+			value: progress,
+			want:  `{"key":"Headers","value":19000000}`,
+		},
+		{
+			name:  "sync stage with more bytes",
+			table: "SyncStage",
+			key:   []byte("Execution"),
+			value: append(progress, 1, 2, 3),
+			want:  `{"key":"Execution","value":19000000}`,
+		},
+		{
+			name:  "sync stage without progress",
+			table: "SyncStage",
+			key:   []byte("Senders"),
+			want:  `{"key":"Senders","value":0}`,
+		},
+		{
+			name:    "sync stage with a short value",
+			table:   "SyncStage",
+			key:     []byte("Headers"),
+			value:   progress[:4],
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := "chaindata"
+
+			if tt.table == syncStageTable {
+				db = syncStageDb
+			}
+
+			decode, ok := FindTableDecoder(db, tt.table)
+
+			if !ok {
+				t.Fatalf("no decoder for %s %s", db, tt.table)
+			}
+
+			row, err := decode(tt.key, tt.value)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %+v, want an error", row)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := json.Marshal(row); err != nil || !strings.Contains(string(got), tt.want) {
+				t.Fatalf("got %s, %v, want it to contain %s", got, err, tt.want)
+			}
+		})
+	}
+}
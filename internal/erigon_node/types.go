@@ -6,37 +6,25 @@ type LogContent struct {
 	Chunk  []byte `json:"chunk"`
 }
 
+// BodyDownload is a frame of the block body download state, starting at BlockNum
 type BodyDownload struct {
-	Legends       [9]bool
-	BlockNum      uint64
-	Pre1          []struct{}
-	Pre10         []struct{}
-	Pre100        []struct{}
-	Pre1_000      []struct{}
-	Pre10_000     []struct{}
-	Pre100_000    []struct{}
-	Pre1_000_000  []struct{}
-	Pre10_000_000 []struct{}
-	States        []SnapshotItem
+	Tick     int64          `json:"tick"`
+	BlockNum uint64         `json:"blockNum"`
+	Legends  [9]bool        `json:"legends"`
+	States   []SnapshotItem `json:"states"`
 }
 
+// HeaderDownload is a frame of the header download state, starting at HeaderNum
 type HeaderDownload struct {
-	Legends       [9]bool
-	HeaderNum     uint64
-	Pre1          []struct{}
-	Pre10         []struct{}
-	Pre100        []struct{}
-	Pre1_000      []struct{}
-	Pre10_000     []struct{}
-	Pre100_000    []struct{}
-	Pre1_000_000  []struct{}
-	Pre10_000_000 []struct{}
-	States        []SnapshotItem
+	Tick      int64          `json:"tick"`
+	HeaderNum uint64         `json:"headerNum"`
+	Legends   [9]bool        `json:"legends"`
+	States    []SnapshotItem `json:"states"`
 }
 
 type SnapshotItem struct {
-	Id    uint64
-	State byte
+	Id    uint64 `json:"id"`
+	State byte   `json:"state"`
 }
 
 const SuccessLine = "SUCCESS"
@@ -0,0 +1,114 @@
+package fakenode
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	downloadWindow    = 64   // items downloading at once
+	downloadMaxState  = 5    // state items reach before they are done and removed
+	downloadMaxChange = 4096 // changes kept to report, older ticks are answered with a snapshot
+)
+
+// downloadSimulation is a simulated header or block body download, in which a window of items
+// moves through states 1 to downloadMaxState, advancing on every report. It is reported in the
+// text format Erigon uses, as a snapshot of the state or the changes since the tick asked for
+type downloadSimulation struct {
+	lock    sync.Mutex
+	next    uint64          // id of the next item to start downloading
+	states  map[uint64]byte // of the items downloading
+	base    int64           // tick changes starts from
+	changes []downloadChange
+}
+
+// downloadChange moves the simulation on by one tick, state 0 removes the item
+type downloadChange struct {
+	id    uint64
+	state byte
+}
+
+func newDownloadSimulation() *downloadSimulation {
+	return &downloadSimulation{states: map[uint64]byte{}}
+}
+
+func (d *downloadSimulation) tick() int64 {
+	return d.base + int64(len(d.changes))
+}
+
+func (d *downloadSimulation) set(id uint64, state byte) {
+	if state == 0 {
+		delete(d.states, id)
+	} else {
+		d.states[id] = state
+	}
+
+	d.changes = append(d.changes, downloadChange{id: id, state: state})
+}
+
+func (d *downloadSimulation) advance() {
+	ids := make([]uint64, 0, len(d.states))
+
+	for id := range d.states {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		if state := d.states[id]; state < downloadMaxState {
+			d.set(id, state+1)
+		} else {
+			d.set(id, 0)
+		}
+	}
+
+	for len(d.states) < downloadWindow {
+		d.set(d.next, 1)
+		d.next++
+	}
+
+	if excess := len(d.changes) - downloadMaxChange; excess > 0 {
+		d.changes = slices.Delete(d.changes, 0, excess)
+		d.base += int64(excess)
+	}
+}
+
+// report advances the download and returns the changes since sinceTick, or a snapshot of
+// the state when sinceTick is 0 or the changes since it are no longer kept
+func (d *downloadSimulation) report(sinceTick int64) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.advance()
+
+	var text strings.Builder
+
+	if sinceTick <= 0 || sinceTick < d.base || sinceTick > d.tick() {
+		text.WriteString("snapshot " + strconv.FormatInt(d.tick(), 10) + "\n")
+
+		ids := make([]uint64, 0, len(d.states))
+
+		for id := range d.states {
+			ids = append(ids, id)
+		}
+
+		slices.Sort(ids)
+
+		for _, id := range ids {
+			text.WriteString(strconv.FormatUint(id, 10) + "," + strconv.Itoa(int(d.states[id])) + "\n")
+		}
+
+		return text.String()
+	}
+
+	text.WriteString("changes " + strconv.FormatInt(sinceTick, 10) + "\n")
+
+	for _, change := range d.changes[sinceTick-d.base:] {
+		text.WriteString(strconv.FormatUint(change.id, 10) + "," + strconv.Itoa(int(change.state)) + "\n")
+	}
+
+	return text.String()
+}
@@ -0,0 +1,215 @@
+package fakenode
+
+import (
+	"bytes"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Fixtures are the data a fake node serves, read from a directory laid out as:
+//
+//	dbs/<db>/<table>.json          rows of the table, as a list of {"key", "value"} objects
+//	logs/<file>                    log files
+//	data/<method>.json             results of methods such as peers or nodeinfo
+//	subscriptions/<service>.json   list of messages sent in turn to the subscribers of the service
+//
+// Keys and values of table rows are given as 0x prefixed hex, or else as text
+type Fixtures struct {
+	dbs           map[string]map[string][]row
+	logs          map[string][]byte
+	data          map[string]json.RawMessage
+	subscriptions map[string][]json.RawMessage
+}
+
+type row struct {
+	Key   fixtureBytes `json:"key"`
+	Value fixtureBytes `json:"value"`
+}
+
+type fixtureBytes []byte
+
+func (b *fixtureBytes) UnmarshalText(text []byte) error {
+	if !bytes.HasPrefix(text, []byte("0x")) {
+		*b = slices.Clone(text)
+		return nil
+	}
+
+	decoded, err := hex.DecodeString(string(text[2:]))
+
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+//go:embed fixtures
+var defaultFixtures embed.FS
+
+// DefaultFixtures returns the fixtures built into the fake node: a chaindata database
+// with the SyncStage, CanonicalHeader, HeaderNumber, Header and BlockBody tables, a log
+// file and the results of the node data methods
+func DefaultFixtures() (*Fixtures, error) {
+	root, err := fs.Sub(defaultFixtures, "fixtures")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadFixtures(root)
+}
+
+// LoadFixtures reads the fixtures from the file system, any of its directories may be missing
+func LoadFixtures(fsys fs.FS) (*Fixtures, error) {
+	fixtures := &Fixtures{
+		dbs:           map[string]map[string][]row{},
+		logs:          map[string][]byte{},
+		data:          map[string]json.RawMessage{},
+		subscriptions: map[string][]json.RawMessage{},
+	}
+
+	err := walkFixtures(fsys, "dbs", func(name string, content []byte) error {
+		db, table := path.Split(strings.TrimSuffix(name, ".json"))
+		db = strings.TrimSuffix(db, "/")
+
+		if db == "" {
+			return fmt.Errorf("table %s is not in a db directory", name)
+		}
+
+		var rows []row
+
+		if err := json.Unmarshal(content, &rows); err != nil {
+			return err
+		}
+
+		// the cursor reads tables in key order
+		sort.SliceStable(rows, func(i, j int) bool {
+			return bytes.Compare(rows[i].Key, rows[j].Key) < 0
+		})
+
+		if fixtures.dbs[db] == nil {
+			fixtures.dbs[db] = map[string][]row{}
+		}
+
+		fixtures.dbs[db][table] = rows
+		return nil
+	})
+
+	if err == nil {
+		err = walkFixtures(fsys, "logs", func(name string, content []byte) error {
+			fixtures.logs[name] = content
+			return nil
+		})
+	}
+
+	if err == nil {
+		err = walkFixtures(fsys, "data", func(name string, content []byte) error {
+			if !json.Valid(content) {
+				return errors.New("invalid JSON")
+			}
+
+			fixtures.data[strings.TrimSuffix(name, ".json")] = content
+			return nil
+		})
+	}
+
+	if err == nil {
+		err = walkFixtures(fsys, "subscriptions", func(name string, content []byte) error {
+			var messages []json.RawMessage
+
+			if err := json.Unmarshal(content, &messages); err != nil {
+				return err
+			}
+
+			fixtures.subscriptions[strings.TrimSuffix(name, ".json")] = messages
+			return nil
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fixtures, nil
+}
+
+// walkFixtures calls read with the content of each file below dir, named by its path within dir
+func walkFixtures(fsys fs.FS, dir string, read func(name string, content []byte) error) error {
+	err := fs.WalkDir(fsys, dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+
+		if err == nil {
+			err = read(strings.TrimPrefix(name, dir+"/"), content)
+		}
+
+		if err != nil {
+			return fmt.Errorf("reading fixture %s: %w", name, err)
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Methods returns the node data methods with fixtures
+func (f *Fixtures) Methods() []string {
+	methods := make([]string, 0, len(f.data))
+
+	for method := range f.data {
+		methods = append(methods, method)
+	}
+
+	slices.Sort(methods)
+	return methods
+}
+
+func (f *Fixtures) dbNames() []string {
+	names := make([]string, 0, len(f.dbs))
+
+	for name := range f.dbs {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// tableRows returns the rows of the table from the first with a key >= startKey, at most limit
+// of them, together with the position of the first in the table and the number of rows it has
+func (f *Fixtures) tableRows(db string, table string, startKey []byte, limit int) ([]row, int, int, bool) {
+	rows, ok := f.dbs[db][table]
+
+	if !ok {
+		return nil, 0, 0, false
+	}
+
+	offset := sort.Search(len(rows), func(i int) bool {
+		return bytes.Compare(rows[i].Key, startKey) >= 0
+	})
+
+	end := len(rows)
+
+	if limit > 0 {
+		end = min(end, offset+limit)
+	}
+
+	return rows[offset:end], offset, len(rows), true
+}
@@ -0,0 +1,296 @@
+package fakenode
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+)
+
+// JSON-RPC error codes the node fails requests with
+const (
+	errorCodeMethodNotFound int64 = -32601
+	errorCodeInvalidParams  int64 = -32602
+	errorCodeInternal       int64 = -32603
+)
+
+func methodNotFound(method string) *erigon_node.Error {
+	return &erigon_node.Error{Code: errorCodeMethodNotFound, Message: "method not found: " + method}
+}
+
+func invalidParams(format string, args ...interface{}) *erigon_node.Error {
+	return &erigon_node.Error{Code: errorCodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// splitMethod separates the query, which some methods carry, from the method
+func splitMethod(method string) (string, url.Values) {
+	method, query, _ := strings.Cut(method, "?")
+	values, _ := url.ParseQuery(query)
+	return method, values
+}
+
+// handle answers the request, an error fails it unless the request was cancelled
+func (n *Node) handle(ctx context.Context, c *connection, request erigon_node.Request) error {
+	method, query := splitMethod(request.Method)
+
+	if request.Params != nil {
+		for key, values := range request.Params.QueryParams {
+			query[key] = append(query[key], values...)
+		}
+	}
+
+	switch {
+	case method == "dbs":
+		return c.respond(request.Id, n.config.Fixtures.dbNames(), nil, true)
+	case strings.HasPrefix(method, "dbs/"):
+		return n.serveDb(c, request.Id, strings.TrimPrefix(method, "dbs/"), query)
+	case method == "logs":
+		return n.serveLogList(c, request.Id)
+	case strings.HasPrefix(method, "logs/"):
+		return n.serveLog(ctx, c, request.Id, strings.TrimPrefix(method, "logs/"), query)
+	case strings.HasPrefix(method, "subscribe/"):
+		return n.serveSubscription(ctx, c, request.Id, strings.TrimPrefix(method, "subscribe/"))
+	case strings.HasPrefix(method, "unsubscribe/"):
+		c.unsubscribe(strings.TrimPrefix(method, "unsubscribe/"))
+		return c.respond(request.Id, nil, nil, true)
+	case method == "headers_download":
+		return serveDownload(c, request.Id, n.headers, query)
+	case method == "block_body_download":
+		return serveDownload(c, request.Id, n.bodies, query)
+	case strings.Contains(method, "pprof"):
+		return serveProfile(c, request.Id, path.Base(method))
+	}
+
+	if data, ok := n.config.Fixtures.data[method]; ok {
+		return c.respond(request.Id, data, nil, true)
+	}
+
+	return methodNotFound(method)
+}
+
+// serveDb answers "<db>/tables" with the tables of the db and "<db>/tables/<table>/<start key>"
+// with the rows from the start key, which is base64 URL encoded, up to the limit in the query
+func (n *Node) serveDb(c *connection, id string, method string, query url.Values) error {
+	db, rest, _ := strings.Cut(method, "/tables")
+	tables, ok := n.config.Fixtures.dbs[db]
+
+	if !ok {
+		return invalidParams("unknown db: %s", db)
+	}
+
+	if rest == "" {
+		names := make([]string, 0, len(tables))
+
+		for name := range tables {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		result := make(erigon_node.Tables, 0, len(names))
+
+		for _, name := range names {
+			table := erigon_node.Table{Name: name, Count: uint64(len(tables[name]))}
+
+			for _, row := range tables[name] {
+				table.Size += uint64(len(row.Key) + len(row.Value))
+			}
+
+			result = append(result, table)
+		}
+
+		return c.respond(id, result, nil, true)
+	}
+
+	table, encodedKey, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+
+	startKey, err := base64.URLEncoding.DecodeString(encodedKey)
+
+	if err != nil {
+		return invalidParams("invalid start key %q: %v", encodedKey, err)
+	}
+
+	var limit int
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			return invalidParams("invalid limit: %s", limitStr)
+		}
+	}
+
+	rows, offset, count, ok := n.config.Fixtures.tableRows(db, table, startKey, limit)
+
+	if !ok {
+		return invalidParams("unknown table %s of db %s", table, db)
+	}
+
+	// results are an object of the base64 URL encoded keys and values, in key order
+	var results bytes.Buffer
+
+	results.WriteByte('{')
+
+	for i, row := range rows {
+		if i > 0 {
+			results.WriteByte(',')
+		}
+
+		results.WriteString(`"` + base64.URLEncoding.EncodeToString(row.Key) + `":"` + base64.URLEncoding.EncodeToString(row.Value) + `"`)
+	}
+
+	results.WriteByte('}')
+
+	return c.respond(id, struct {
+		Offset  int             `json:"offset"`
+		Limit   int             `json:"limit"`
+		Count   int             `json:"count"`
+		Results json.RawMessage `json:"results"`
+	}{offset, limit, count, results.Bytes()}, nil, true)
+}
+
+func (n *Node) serveLogList(c *connection, id string) error {
+	type logFile struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+
+	files := []logFile{}
+
+	for name, content := range n.config.Fixtures.logs {
+		files = append(files, logFile{Name: name, Size: int64(len(content))})
+	}
+
+	slices.SortFunc(files, func(a, b logFile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return c.respond(id, files, nil, true)
+}
+
+// serveLog sends the log file from the offset in the query, up to its limit, in chunks
+func (n *Node) serveLog(ctx context.Context, c *connection, id string, file string, query url.Values) error {
+	content, ok := n.config.Fixtures.logs[file]
+
+	if !ok {
+		return invalidParams("unknown log file: %s", file)
+	}
+
+	offset, limit := int64(0), int64(len(content))
+
+	for name, value := range map[string]*int64{"offset": &offset, "limit": &limit} {
+		if s := query.Get(name); s != "" {
+			v, err := strconv.ParseInt(s, 10, 64)
+
+			if err != nil || v < 0 {
+				return invalidParams("invalid %s: %s", name, s)
+			}
+
+			*value = v
+		}
+	}
+
+	offset = min(offset, int64(len(content)))
+	end := min(offset+limit, int64(len(content)))
+
+	for {
+		chunkEnd := min(offset+int64(c.chunkSize), end)
+		chunk := content[offset:chunkEnd]
+		logContent := erigon_node.LogContent{Offset: offset, Size: int64(len(content))}
+
+		// the chunk is sent raw from BridgeProtocolV3 on, rather than base64 encoded in the result
+		var raw []byte
+
+		if c.binary() {
+			raw = chunk
+		} else {
+			logContent.Chunk = chunk
+		}
+
+		if err := c.respond(id, logContent, raw, chunkEnd == end); err != nil {
+			return err
+		}
+
+		if chunkEnd == end || ctx.Err() != nil {
+			return nil
+		}
+
+		offset = chunkEnd
+	}
+}
+
+// serveSubscription sends a message to the subscriber every SubscriptionInterval, cycling
+// through the fixture messages of the service, until the subscription is cancelled
+func (n *Node) serveSubscription(ctx context.Context, c *connection, id string, service string) error {
+	messages := n.config.Fixtures.subscriptions[service]
+
+	ticker := time.NewTicker(n.config.SubscriptionInterval)
+	defer ticker.Stop()
+
+	for seq := 0; ; seq++ {
+		var message interface{}
+
+		if len(messages) > 0 {
+			message = messages[seq%len(messages)]
+		} else {
+			message = struct {
+				Service string    `json:"service"`
+				Seq     int       `json:"seq"`
+				Time    time.Time `json:"time"`
+			}{service, seq, time.Now()}
+		}
+
+		if err := c.respond(id, message, nil, false); err != nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func serveDownload(c *connection, id string, download *downloadSimulation, query url.Values) error {
+	var sinceTick int64
+
+	if s := query.Get("sinceTick"); s != "" {
+		var err error
+
+		if sinceTick, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return invalidParams("invalid sinceTick: %s", s)
+		}
+	}
+
+	return c.respond(id, download.report(sinceTick), nil, true)
+}
+
+// serveProfile sends a profile of the fake node itself, such as of its goroutines or heap
+func serveProfile(c *connection, id string, name string) error {
+	profile := pprof.Lookup(name)
+
+	if profile == nil {
+		return invalidParams("unknown profile: %s", name)
+	}
+
+	var content bytes.Buffer
+
+	if err := profile.WriteTo(&content, 0); err != nil {
+		return err
+	}
+
+	if c.binary() {
+		return c.respond(id, erigon_node.ProfileContent{}, content.Bytes(), true)
+	}
+
+	return c.respond(id, erigon_node.ProfileContent{Chunk: content.Bytes()}, nil, true)
+}
@@ -0,0 +1,451 @@
+// Package fakenode simulates an Erigon node connected to diagnostics over the bridge, answering
+// its requests from fixture data, so the server and UI can be developed and tried without
+// running a node
+package fakenode
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+	"github.com/erigontech/diagnostics/internal/sessions"
+)
+
+const (
+	defaultChunkSize            = 64 * 1024
+	defaultSubscriptionInterval = time.Second
+
+	reconnectDelay    = time.Second
+	reconnectDelayMax = 30 * time.Second
+	handshakeTimeout  = 10 * time.Second
+	writeTimeout      = 10 * time.Second
+)
+
+// Config describes the fake node and the bridge it connects to
+type Config struct {
+	URL       string            // of the bridge, such as ws://localhost:8080/bridge
+	Sessions  map[string][]byte // bridge secret of each session to attach to, by session id (PIN)
+	NodeId    string
+	NodeName  string
+	Version   uint64      // newest bridge protocol version to speak, defaults to the newest supported
+	TLSConfig *tls.Config // for wss URLs, such as to present a client certificate
+	Fixtures  *Fixtures
+	// ChunkSize is the size of the log chunks, and from BridgeProtocolV3 of the binary frames results are split into
+	ChunkSize int
+	// SubscriptionInterval is the time between the messages sent to subscribers
+	SubscriptionInterval time.Duration
+	Verbose              bool // logs every request
+}
+
+// Node is a fake Erigon node, it answers dbs, logs, subscribe, headers_download,
+// block_body_download, pprof and the node data methods with fixtures
+type Node struct {
+	config      Config
+	headers     *downloadSimulation
+	bodies      *downloadSimulation
+	resumeToken string // issued with the last accepted connection
+}
+
+func New(config Config) *Node {
+	if config.Version == 0 {
+		config.Version = sessions.SupportedBridgeVersions[0]
+	}
+
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultChunkSize
+	}
+
+	if config.SubscriptionInterval <= 0 {
+		config.SubscriptionInterval = defaultSubscriptionInterval
+	}
+
+	if config.Fixtures == nil {
+		config.Fixtures = &Fixtures{}
+	}
+
+	return &Node{
+		config:  config,
+		headers: newDownloadSimulation(),
+		bodies:  newDownloadSimulation(),
+	}
+}
+
+// Capabilities are the methods the node advertises from BridgeProtocolV2 on
+func (n *Node) Capabilities() []string {
+	capabilities := []string{
+		"dbs", "logs", "subscribe", "unsubscribe", "headers_download", "block_body_download", "pprof",
+	}
+
+	return append(capabilities, n.config.Fixtures.Methods()...)
+}
+
+// Run keeps the node connected to the bridge until ctx is cancelled. A lost connection is
+// redialled with backoff and resumed with the token the bridge issued for it. Run only
+// returns early when the bridge rejects the node's handshake
+func (n *Node) Run(ctx context.Context) error {
+	delay := reconnectDelay
+
+	for {
+		conn, version, err := n.connect(ctx)
+
+		if errors.Is(err, sessions.ErrHandshakeRejected) {
+			return err
+		}
+
+		if err == nil {
+			delay = reconnectDelay
+			log.Printf("Connected to %s with protocol version %d\n", n.config.URL, version)
+
+			err = n.serve(ctx, conn, version)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Printf("Bridge connection lost, reconnecting in %v: %v\n", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+
+		delay = min(2*delay, reconnectDelayMax)
+	}
+}
+
+// connect dials the bridge and answers its challenge, returning the protocol version agreed
+func (n *Node) connect(ctx context.Context) (*websocket.Conn, uint64, error) {
+	dialer := websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  handshakeTimeout,
+		TLSClientConfig:   n.config.TLSConfig,
+		EnableCompression: true,
+	}
+
+	conn, _, err := dialer.DialContext(ctx, n.config.URL, nil)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("dialing bridge: %w", err)
+	}
+
+	version, err := n.handshake(conn)
+
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	return conn, version, nil
+}
+
+func (n *Node) handshake(conn *websocket.Conn) (uint64, error) {
+	sessionIds := make([]string, 0, len(n.config.Sessions))
+
+	for sessionId := range n.config.Sessions {
+		sessionIds = append(sessionIds, sessionId)
+	}
+
+	connectionInfo := struct {
+		Version  uint64               `json:"version"`
+		Sessions []string             `json:"sessions"`
+		Nodes    []*sessions.NodeInfo `json:"nodes"`
+	}{
+		Version:  n.config.Version,
+		Sessions: sessionIds,
+		Nodes:    []*sessions.NodeInfo{{Id: n.config.NodeId, Name: n.config.NodeName}},
+	}
+
+	deadline := time.Now().Add(handshakeTimeout)
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	defer conn.SetWriteDeadline(time.Time{})
+
+	if err := conn.WriteJSON(connectionInfo); err != nil {
+		return 0, fmt.Errorf("sending connection info: %w", err)
+	}
+
+	var challenge sessions.HandshakeMessage
+
+	if err := conn.ReadJSON(&challenge); err != nil {
+		return 0, fmt.Errorf("reading challenge: %w", err)
+	}
+
+	if challenge.Type != sessions.HandshakeChallenge {
+		return 0, fmt.Errorf("expected a challenge, got %q", challenge.Type)
+	}
+
+	version := negotiateVersion(n.config.Version, challenge.Versions)
+
+	if version == 0 {
+		return 0, fmt.Errorf("%w: no common protocol version in %v", sessions.ErrHandshakeRejected, challenge.Versions)
+	}
+
+	payload := sessions.HandshakePayload(challenge.Nonce, sessionIds, []string{n.config.NodeId})
+
+	response := sessions.HandshakeResponse{
+		Macs:    map[string][]byte{},
+		Version: version,
+	}
+
+	for sessionId, secret := range n.config.Sessions {
+		response.Macs[sessionId] = sessions.HandshakeMac(secret, payload)
+	}
+
+	if version >= sessions.BridgeProtocolV2 {
+		response.Capabilities = n.Capabilities()
+		response.ResumeToken = n.resumeToken
+	}
+
+	if err := conn.WriteJSON(response); err != nil {
+		return 0, fmt.Errorf("sending challenge response: %w", err)
+	}
+
+	var result sessions.HandshakeMessage
+
+	if err := conn.ReadJSON(&result); err != nil {
+		return 0, fmt.Errorf("reading handshake result: %w", err)
+	}
+
+	if result.Type != sessions.HandshakeAccepted {
+		return 0, fmt.Errorf("%w: %s", sessions.ErrHandshakeRejected, result.Error)
+	}
+
+	n.resumeToken = result.ResumeToken
+
+	if result.Version != 0 {
+		version = result.Version
+	}
+
+	return version, nil
+}
+
+// negotiateVersion returns the newest version up to preferred which the server supports, 0 if none.
+// Servers which do not list their versions are taken to speak BridgeProtocolV1
+func negotiateVersion(preferred uint64, supported []uint64) uint64 {
+	if len(supported) == 0 {
+		return sessions.BridgeProtocolV1
+	}
+
+	var version uint64
+
+	for _, v := range supported {
+		if v <= preferred && v > version {
+			version = v
+		}
+	}
+
+	return version
+}
+
+// serve answers the requests which arrive over the connection until it is lost or ctx is cancelled
+func (n *Node) serve(ctx context.Context, conn *websocket.Conn, version uint64) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := &connection{
+		conn:      conn,
+		version:   version,
+		chunkSize: n.config.ChunkSize,
+		requests:  map[string]*inFlight{},
+	}
+
+	wg := &sync.WaitGroup{}
+
+	defer func() {
+		cancel()
+		wg.Wait()
+		conn.Close()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		// unblocks the read below when the node is stopped
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+		conn.Close()
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+
+		if err != nil {
+			return err
+		}
+
+		var request erigon_node.Request
+
+		if err := json.Unmarshal(message, &request); err != nil {
+			log.Printf("Ignoring invalid request: %v\n", err)
+			continue
+		}
+
+		if request.Method == erigon_node.CancelMethod {
+			c.cancel(request.Id)
+			continue
+		}
+
+		if n.config.Verbose {
+			log.Printf("Request %s: %s\n", request.Id, request.Method)
+		}
+
+		requestCtx, done := c.start(ctx, request)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer done()
+
+			if err := n.handle(requestCtx, c, request); err != nil && requestCtx.Err() == nil {
+				c.fail(request.Id, err)
+			}
+		}()
+	}
+}
+
+// connection is a bridge connection with the requests in flight over it
+type connection struct {
+	conn      *websocket.Conn
+	version   uint64
+	chunkSize int
+
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	requests map[string]*inFlight
+}
+
+type inFlight struct {
+	cancel  context.CancelFunc
+	service string // subscribed to, for subscriptions
+}
+
+// start tracks the request until the returned func is called, its context is cancelled
+// when the server cancels the request or the caller's deadline passes
+func (c *connection) start(ctx context.Context, request erigon_node.Request) (context.Context, func()) {
+	var cancel context.CancelFunc
+
+	if request.Deadline != nil {
+		ctx, cancel = context.WithDeadline(ctx, *request.Deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	method, _ := splitMethod(request.Method)
+	service, _ := strings.CutPrefix(method, "subscribe/")
+
+	c.lock.Lock()
+	c.requests[request.Id] = &inFlight{cancel: cancel, service: service}
+	c.lock.Unlock()
+
+	return ctx, func() {
+		c.lock.Lock()
+		delete(c.requests, request.Id)
+		c.lock.Unlock()
+
+		cancel()
+	}
+}
+
+func (c *connection) cancel(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if request, ok := c.requests[id]; ok {
+		request.cancel()
+	}
+}
+
+// unsubscribe ends the subscriptions to the service, returning how many there were
+func (c *connection) unsubscribe(service string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var count int
+
+	for _, request := range c.requests {
+		if request.service == service {
+			request.cancel()
+			count++
+		}
+	}
+
+	return count
+}
+
+// binary returns true when results may be sent in binary chunks
+func (c *connection) binary() bool {
+	return c.version >= sessions.BridgeProtocolV3
+}
+
+func (c *connection) write(messageType int, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// respond sends the result, in binary chunks when the connection allows and the
+// result is larger than a chunk or has raw content, which is sent in raw chunks
+func (c *connection) respond(id string, result interface{}, raw []byte, last bool) error {
+	encoded, err := json.Marshal(result)
+
+	if err != nil {
+		return err
+	}
+
+	response := &erigon_node.Response{Id: id, Result: encoded, Last: last}
+
+	if c.binary() && (raw != nil || len(encoded) > c.chunkSize) {
+		frames, err := erigon_node.ChunkFrames(response, raw, c.chunkSize)
+
+		if err != nil {
+			return err
+		}
+
+		for _, frame := range frames {
+			if err := c.write(websocket.BinaryMessage, frame); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	message, err := json.Marshal(response)
+
+	if err != nil {
+		return err
+	}
+
+	return c.write(websocket.TextMessage, message)
+}
+
+func (c *connection) fail(id string, err error) {
+	var requestErr *erigon_node.Error
+
+	if !errors.As(err, &requestErr) {
+		requestErr = &erigon_node.Error{Code: errorCodeInternal, Message: err.Error()}
+	}
+
+	message, err := json.Marshal(&erigon_node.Response{Id: id, Error: requestErr, Last: true})
+
+	if err == nil {
+		err = c.write(websocket.TextMessage, message)
+	}
+
+	if err != nil {
+		log.Printf("Error failing request %s: %v\n", id, err)
+	}
+}
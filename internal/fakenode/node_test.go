@@ -0,0 +1,353 @@
+package fakenode_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erigontech/diagnostics/api"
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+	"github.com/erigontech/diagnostics/internal/fakenode"
+	"github.com/erigontech/diagnostics/internal/sessions"
+)
+
+const testNodeId = "fake-node"
+
+// testServer is the diagnostics server with a UI session the fake node attaches to
+type testServer struct {
+	*httptest.Server
+	cache   sessions.CacheService
+	session api.IssueSessionResponse
+}
+
+func newTestServer(t *testing.T) *testServer {
+	cache, err := sessions.NewCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := sessions.NewTokenSigner(nil, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testServer{
+		Server: httptest.NewServer(api.NewHandler(api.APIServices{StoreSession: cache, SessionTokens: tokens})),
+		cache:  cache,
+	}
+
+	t.Cleanup(server.Close)
+
+	response, err := http.Post(server.URL+"/api/sessions", "application/json", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("issuing a session got status %d", response.StatusCode)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&server.session); err != nil {
+		t.Fatal(err)
+	}
+
+	return server
+}
+
+func (s *testServer) sessionId() string {
+	return strconv.FormatUint(s.session.SessionPin, 10)
+}
+
+// get reads the path below the session's API, failing the test unless it succeeds
+func (s *testServer) get(t *testing.T, path string) []byte {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, s.URL+"/api/sessions/"+s.sessionId()+path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+s.session.Token)
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s got status %d: %s", path, response.StatusCode, body)
+	}
+
+	return body
+}
+
+// connect runs a fake node attached to the server's session until the test ends,
+// returning the client the server talks to it with once it has connected
+func (s *testServer) connect(t *testing.T, version uint64) erigon_node.Client {
+	secret, err := hex.DecodeString(s.session.BridgeSecret)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := fakenode.DefaultFixtures()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := fakenode.New(fakenode.Config{
+		URL:       "ws" + strings.TrimPrefix(s.URL, "http") + "/bridge",
+		Sessions:  map[string][]byte{s.sessionId(): secret},
+		NodeId:    testNodeId,
+		NodeName:  "fake",
+		Version:   version,
+		Fixtures:  fixtures,
+		ChunkSize: 1024, // so that results are split into binary chunks from BridgeProtocolV3 on
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- node.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		select {
+		case err := <-done:
+			t.Fatalf("node stopped: %v", err)
+		default:
+		}
+
+		if session, ok := s.cache.FindNodeSession(testNodeId); ok && session.IsConnected() {
+			return session.Client
+		}
+	}
+
+	t.Fatal("node did not connect")
+	return nil
+}
+
+func TestFakeNode(t *testing.T) {
+	for _, version := range sessions.SupportedBridgeVersions {
+		t.Run(fmt.Sprintf("protocol version %d", version), func(t *testing.T) {
+			server := newTestServer(t)
+			client := server.connect(t, version)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			t.Run("handshake", func(t *testing.T) {
+				var session api.SessionResponse
+
+				if err := json.Unmarshal(server.get(t, ""), &session); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(session.Nodes) != 1 || session.Nodes[0].Id != testNodeId || !session.Nodes[0].Connected {
+					t.Fatalf("session has nodes %+v", session.Nodes)
+				}
+
+				node := session.Nodes[0]
+
+				if node.ProtocolVersion != version {
+					t.Fatalf("node speaks protocol version %d", node.ProtocolVersion)
+				}
+
+				if advertises := slices.Contains(node.Capabilities, "dbs"); advertises != (version >= sessions.BridgeProtocolV2) {
+					t.Fatalf("node of protocol version %d has capabilities %v", version, node.Capabilities)
+				}
+			})
+
+			t.Run("dbs", func(t *testing.T) {
+				dbs, err := client.GetResponse(ctx, "dbs")
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if fmt.Sprint(dbs) != "[chaindata]" {
+					t.Fatalf("dbs are %v", dbs)
+				}
+
+				var tables erigon_node.Tables
+
+				if err := json.Unmarshal(server.get(t, "/nodes/"+testNodeId+"/dbs/chaindata/tables"), &tables); err != nil {
+					t.Fatal(err)
+				}
+
+				counts := map[string]uint64{}
+
+				for _, table := range tables {
+					counts[table.Name] = table.Count
+				}
+
+				for _, table := range []string{"SyncStage", "CanonicalHeader", "HeaderNumber", "Header", "BlockBody"} {
+					if counts[table] == 0 {
+						t.Errorf("table %s is missing or empty in %v", table, tables)
+					}
+				}
+			})
+
+			t.Run("cursor", func(t *testing.T) {
+				rc := erigon_node.NewRemoteCursor(client, erigon_node.WithChunkSize(16), erigon_node.WithPrefetch(2))
+				defer rc.Close()
+
+				if err := rc.Init(ctx, "chaindata", "Header", nil); err != nil {
+					t.Fatal(err)
+				}
+
+				decode, ok := erigon_node.FindTableDecoder("chaindata", "Header")
+
+				if !ok {
+					t.Fatal("no Header decoder")
+				}
+
+				var keys [][]byte
+
+				for {
+					k, v, err := rc.Next(ctx)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if k == nil {
+						break
+					}
+
+					if _, err := decode(k, v); err != nil {
+						t.Fatalf("decoding header %x: %v", k, err)
+					}
+
+					keys = append(keys, k)
+				}
+
+				// 134 canonical headers and 3 of forks
+				if len(keys) != 137 {
+					t.Fatalf("read %d headers", len(keys))
+				}
+
+				k, _, err := rc.Last(ctx)
+
+				for i := len(keys) - 1; i >= len(keys)-20; i-- {
+					if err != nil || !bytes.Equal(k, keys[i]) {
+						t.Fatalf("walking back got %x, %v, want %x", k, err, keys[i])
+					}
+
+					k, _, err = rc.Prev(ctx)
+				}
+			})
+
+			t.Run("decoded tables", func(t *testing.T) {
+				for _, table := range []string{"Header", "BlockBody", "CanonicalHeader", "HeaderNumber", "SyncStage"} {
+					var response api.TableResponse
+
+					if err := json.Unmarshal(server.get(t, "/nodes/"+testNodeId+"/dbs/chaindata/tables/"+table+"?limit=50"), &response); err != nil {
+						t.Fatal(err)
+					}
+
+					if len(response.Rows) == 0 || response.NextKey == "" && table != "SyncStage" {
+						t.Fatalf("%s has %d rows, next key %q", table, len(response.Rows), response.NextKey)
+					}
+
+					for _, row := range response.Rows {
+						if row.Decoded == nil {
+							t.Fatalf("%s row %s was not decoded: %s", table, row.Key, row.DecodeError)
+						}
+					}
+				}
+			})
+
+			t.Run("reorgs", func(t *testing.T) {
+				// the fields of erigon_node.Reorg which are checked, its hashes are only encoded
+				var reorg struct {
+					TotalScanned int
+					WrongBlocks  []uint64
+					MaxDepth     int
+					Errors       []string
+					Forks        []struct {
+						ForkPoint       uint64
+						ForkIsCanonical bool
+						Depth           int
+					}
+				}
+
+				if err := json.Unmarshal(server.get(t, "/nodes/"+testNodeId+"/reorgs"), &reorg); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(reorg.Errors) > 0 {
+					t.Fatalf("scan failed: %v", reorg.Errors)
+				}
+
+				if reorg.TotalScanned != 134 || fmt.Sprint(reorg.WrongBlocks) != "[19000040 19000090 19000091]" || reorg.MaxDepth != 2 {
+					t.Fatalf("scanned %d blocks, found %v with depth %d", reorg.TotalScanned, reorg.WrongBlocks, reorg.MaxDepth)
+				}
+
+				if len(reorg.Forks) != 2 {
+					t.Fatalf("found forks %+v", reorg.Forks)
+				}
+
+				for i, want := range []struct {
+					forkPoint uint64
+					depth     int
+				}{{19000039, 1}, {19000089, 2}} {
+					if fork := reorg.Forks[i]; fork.ForkPoint != want.forkPoint || fork.Depth != want.depth || !fork.ForkIsCanonical {
+						t.Errorf("fork %d is %+v, want fork point %d and depth %d", i, fork, want.forkPoint, want.depth)
+					}
+				}
+			})
+
+			t.Run("logs", func(t *testing.T) {
+				want, err := os.ReadFile("fixtures/logs/erigon.log")
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := server.get(t, "/nodes/"+testNodeId+"/logs/erigon.log"); !bytes.Equal(got, want) {
+					t.Fatalf("read %d bytes of the log, want %d", len(got), len(want))
+				}
+
+				got := server.get(t, "/nodes/"+testNodeId+"/logs/erigon.log?offset=100&limit=2000")
+
+				if !bytes.Equal(got, want[100:2100]) {
+					t.Fatalf("read %q from offset 100, want %q", got, want[100:2100])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package sessions
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// sessionStore persists the metadata of the sessions held by a Cache, the
+// connections of the node sessions themselves only live in memory
+type sessionStore interface {
+	saveUISession(session *UISession) error
+	deleteUISession(sessionId string) error
+	saveNode(node *NodeInfo, publicKey ed25519.PublicKey) error
+	deleteNode(nodeId string) error
+	saveAttachment(sessionId string, nodeId string) error
+	close() error
+}
+
+var (
+	uiSessionsBucket = []byte("ui_sessions") // session id -> storedUISession json
+	nodesBucket      = []byte("nodes")       // node id -> storedNode json
+	uiNodesBucket    = []byte("ui_nodes")    // session id -> bucket of attached node ids
+)
+
+type storedUISession struct {
+	Expires      time.Time `json:"expires,omitempty"`
+	BridgeSecret []byte    `json:"bridgeSecret,omitempty"`
+}
+
+type storedNode struct {
+	Info      *NodeInfo `json:"info"`
+	PublicKey []byte    `json:"publicKey,omitempty"`
+}
+
+// boltStore keeps the session metadata in a bbolt database file
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltCache returns a cache whose UI sessions, node metadata and their associations
+// are kept in the bbolt database at path, so they survive a restart. The node sessions
+// are restored disconnected and reattach when their nodes reconnect via the bridge
+func NewBoltCache(path string, maxNodeSessions int, maxUISessions int, options ...CacheOption) (CacheService, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, fmt.Errorf("opening session store %s: %w", path, err)
+	}
+
+	store := &boltStore{db: db}
+
+	if err := store.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	cache, err := newCache(maxNodeSessions, maxUISessions, options...)
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := store.restore(cache); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("restoring sessions from %s: %w", path, err)
+	}
+
+	cache.store = store
+	cache.startExpiry()
+
+	return cache, nil
+}
+
+func (s *boltStore) init() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{uiSessionsBucket, nodesBucket, uiNodesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return fmt.Errorf("creating bucket %s: %w", bucket, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// restore loads the stored sessions into the cache, it is called before the
+// store is set on the cache so nothing is written back while loading
+func (s *boltStore) restore(cache *Cache) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(nodesBucket).ForEach(func(k, v []byte) error {
+			var node storedNode
+
+			if err := json.Unmarshal(v, &node); err != nil {
+				return fmt.Errorf("decoding node %s: %w", k, err)
+			}
+
+			if node.Info == nil {
+				// stored before public keys were pinned, as the bare NodeInfo
+				if err := json.Unmarshal(v, &node.Info); err != nil {
+					return fmt.Errorf("decoding node %s: %w", k, err)
+				}
+			}
+
+			cache.addNodeSession(node.Info).publicKey = node.PublicKey
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(uiNodesBucket).ForEachBucket(func(session []byte) error {
+			return tx.Bucket(uiNodesBucket).Bucket(session).ForEach(func(nodeId, _ []byte) error {
+				if nodeSession, ok := cache.NodeSessions.Peek(string(nodeId)); ok {
+					return nodeSession.AttachSessions([]string{string(session)})
+				}
+
+				return nil
+			})
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(uiSessionsBucket).ForEach(func(k, v []byte) error {
+			var stored storedUISession
+
+			if len(v) > 0 {
+				if err := json.Unmarshal(v, &stored); err != nil {
+					return fmt.Errorf("decoding UI session %s: %w", k, err)
+				}
+			}
+
+			// expired sessions are removed from the store by the next expiry check
+			_, _, err := cache.createUISession(string(k), stored.Expires, stored.BridgeSecret)
+			return err
+		})
+	})
+}
+
+func (s *boltStore) saveUISession(session *UISession) error {
+	value, err := json.Marshal(storedUISession{Expires: session.Expires, BridgeSecret: session.BridgeSecret})
+
+	if err != nil {
+		return fmt.Errorf("encoding UI session %d: %w", session.SessionPin, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uiSessionsBucket).Put([]byte(strconv.FormatUint(session.SessionPin, 10)), value)
+	})
+}
+
+func (s *boltStore) deleteUISession(sessionId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(uiSessionsBucket).Delete([]byte(sessionId)); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(uiNodesBucket).DeleteBucket([]byte(sessionId)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) saveNode(node *NodeInfo, publicKey ed25519.PublicKey) error {
+	value, err := json.Marshal(storedNode{Info: node, PublicKey: publicKey})
+
+	if err != nil {
+		return fmt.Errorf("encoding node %s: %w", node.Id, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(nodesBucket).Put([]byte(node.Id), value)
+	})
+}
+
+func (s *boltStore) deleteNode(nodeId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(nodesBucket).Delete([]byte(nodeId)); err != nil {
+			return err
+		}
+
+		uiNodes := tx.Bucket(uiNodesBucket)
+
+		var empty [][]byte
+
+		err := uiNodes.ForEachBucket(func(session []byte) error {
+			nodes := uiNodes.Bucket(session)
+
+			if err := nodes.Delete([]byte(nodeId)); err != nil {
+				return err
+			}
+
+			if k, _ := nodes.Cursor().First(); k == nil {
+				empty = append(empty, session)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, session := range empty {
+			if err := uiNodes.DeleteBucket(session); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) saveAttachment(sessionId string, nodeId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		nodes, err := tx.Bucket(uiNodesBucket).CreateBucketIfNotExists([]byte(sessionId))
+
+		if err != nil {
+			return err
+		}
+
+		return nodes.Put([]byte(nodeId), nil)
+	})
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
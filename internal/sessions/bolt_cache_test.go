@@ -0,0 +1,129 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBoltCacheRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	open := func() *Cache {
+		t.Helper()
+
+		cache, err := NewBoltCache(path, 10, 10, WithUISessionLifetime(time.Hour), WithNodeSessionTTL(2*time.Hour))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cache.(*Cache)
+	}
+
+	publicKey, _, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := open()
+	issued, err := cache.IssueUISession()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionId := strconv.FormatUint(issued.SessionPin, 10)
+	node, err := cache.CreateNodeSession(&NodeInfo{Id: "node", Name: "erigon"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.AttachSessions([]string{sessionId}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.PinPublicKey(publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = open()
+	session, ok := cache.UISessions.Peek(sessionId)
+
+	if !ok {
+		t.Fatalf("session %s was not restored", sessionId)
+	}
+
+	if !session.Expires.Equal(issued.Expires) || !bytes.Equal(session.BridgeSecret, issued.BridgeSecret) {
+		t.Fatalf("restored session expires at %v with secret %x, want %v and %x", session.Expires, session.BridgeSecret, issued.Expires, issued.BridgeSecret)
+	}
+
+	node, ok = cache.NodeSessions.Peek("node")
+
+	if !ok {
+		t.Fatal("node was not restored")
+	}
+
+	if node.IsConnected() || node.NodeInfo.Name != "erigon" || !node.HasUISession(sessionId) || !node.pinnedKey().Equal(publicKey) {
+		t.Fatalf("restored node %+v", node)
+	}
+
+	if nodes := session.NodeSessions(); len(nodes) != 1 || nodes[0] != node {
+		t.Fatalf("restored session has nodes %v", nodes)
+	}
+
+	// the session outlives its lifetime, and the node is disconnected for longer than its TTL
+	cache.expire(session.Expires.Add(-time.Second))
+
+	if _, ok := cache.UISessions.Peek(sessionId); !ok {
+		t.Fatal("session expired before its lifetime")
+	}
+
+	cache.expire(session.Expires)
+
+	if _, ok := cache.UISessions.Peek(sessionId); ok {
+		t.Fatal("session did not expire after its lifetime")
+	}
+
+	if _, ok := cache.NodeSessions.Peek("node"); !ok {
+		t.Fatal("node expired before its TTL")
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = open()
+
+	if _, ok := cache.UISessions.Peek(sessionId); ok {
+		t.Fatal("expired session was restored")
+	}
+
+	node, ok = cache.NodeSessions.Peek("node")
+
+	if !ok || node.HasUISession(sessionId) {
+		t.Fatalf("restored node %+v, want it without the expired session", node)
+	}
+
+	cache.expire(time.Now().Add(3 * time.Hour))
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = open()
+	defer cache.Close()
+
+	if cache.NodeSessions.Len() != 0 || cache.UISessions.Len() != 0 {
+		t.Fatalf("restored %v nodes and %v sessions after they expired", cache.NodeSessions.Keys(), cache.UISessions.Keys())
+	}
+}
@@ -1,6 +1,13 @@
 package sessions
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
 	lru "github.com/hashicorp/golang-lru/v2"
 
 	"github.com/erigontech/diagnostics/internal/erigon_node"
@@ -8,26 +15,152 @@ import (
 
 var _ CacheService = &Cache{}
 
+// maxExpiryInterval is the longest time between checks for expired sessions
+const maxExpiryInterval = time.Minute
+
+// ErrSessionsFull is returned when issuing a UI session while the cache holds as
+// many live sessions as it may, rather than evicting one which is still in use
+var ErrSessionsFull = errors.New("too many UI sessions, try again later")
+
 type Cache struct {
 	NodeSessions *lru.Cache[string, *NodeSession]
 	UISessions   *lru.Cache[string, *UISession]
 	uiNodeMap    map[string]map[string]*NodeSession
+	lock         sync.Mutex   // guards uiNodeMap
+	store        sessionStore // nil when sessions are only held in memory
+
+	issueLock     sync.Mutex // serialises issuing UI sessions, so the cache is never overfilled
+	maxUISessions int
+
+	pins              PinGenerator
+	uiSessionLifetime time.Duration
+	uiSessionTTL      time.Duration
+	nodeSessionTTL    time.Duration
+	bridgeResumeGrace time.Duration
+	stopExpiry        chan struct{}
+}
+
+type CacheOption func(cache *Cache)
+
+// WithUISessionTTL expires the UI sessions which have not been used for the given time
+func WithUISessionTTL(ttl time.Duration) CacheOption {
+	return func(cache *Cache) {
+		cache.uiSessionTTL = ttl
+	}
+}
+
+// WithUISessionLifetime expires UI sessions the given time after they were issued, however much they are used
+func WithUISessionLifetime(lifetime time.Duration) CacheOption {
+	return func(cache *Cache) {
+		cache.uiSessionLifetime = lifetime
+	}
+}
+
+// WithInsecurePins issues sequential, predictable PINs instead of random ones, for testing
+func WithInsecurePins() CacheOption {
+	return func(cache *Cache) {
+		cache.pins = insecurePins()
+	}
 }
 
-func (s *Cache) CreateUISession(sessionId string) (*UISession, error) {
+// WithNodeSessionTTL expires the node sessions whose nodes have been disconnected for the given time
+func WithNodeSessionTTL(ttl time.Duration) CacheOption {
+	return func(cache *Cache) {
+		cache.nodeSessionTTL = ttl
+	}
+}
+
+// WithBridgeResumeGrace lets nodes resume a lost bridge connection within the given time, keeping
+// the requests which were in flight, 0 disconnects nodes as soon as their connection is lost
+func WithBridgeResumeGrace(grace time.Duration) CacheOption {
+	return func(cache *Cache) {
+		cache.bridgeResumeGrace = grace
+	}
+}
+
+// IssueUISession creates a UI session with a newly generated PIN which is not in use by another session.
+// It fails with ErrSessionsFull when the cache is full of sessions which have not expired
+func (s *Cache) IssueUISession() (*UISession, error) {
+	s.issueLock.Lock()
+	defer s.issueLock.Unlock()
+
+	if s.UISessions.Len() >= s.maxUISessions {
+		s.expire(time.Now())
+
+		if s.UISessions.Len() >= s.maxUISessions {
+			return nil, ErrSessionsFull
+		}
+	}
+
+	var expires time.Time
+
+	if s.uiSessionLifetime > 0 {
+		expires = time.Now().Add(s.uiSessionLifetime)
+	}
+
+	for attempt := 0; attempt < maxPinAttempts; attempt++ {
+		pin, err := s.pins()
+
+		if err != nil {
+			return nil, err
+		}
+
+		secret, err := newBridgeSecret()
+
+		if err != nil {
+			return nil, err
+		}
+
+		session, created, err := s.createUISession(strconv.FormatUint(pin, 10), expires, secret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if created {
+			return session, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no unused session pin found after %d attempts", maxPinAttempts)
+}
+
+// createUISession adds the session with the given id unless there already is one,
+// returning false in that case
+func (s *Cache) createUISession(sessionId string, expires time.Time, bridgeSecret []byte) (*UISession, bool, error) {
 	session, err := NewUISession(sessionId, s)
 
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	s.UISessions.Add(sessionId, session)
+	session.Expires = expires
+	session.BridgeSecret = bridgeSecret
+
+	if exists, _ := s.UISessions.ContainsOrAdd(sessionId, session); exists {
+		return nil, false, nil
+	}
+
+	if s.store != nil {
+		if err := s.store.saveUISession(session); err != nil {
+			s.UISessions.Remove(sessionId)
+			return nil, false, err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
 	for _, node := range s.uiNodeMap[sessionId] {
 		session.Attach(node)
 	}
 
-	return session, nil
+	return session, true, nil
+}
+
+// RevokeUISession removes the session, so its PIN and tokens no longer grant access
+func (s *Cache) RevokeUISession(sessionId string) bool {
+	return s.UISessions.Remove(sessionId)
 }
 
 func (s *Cache) FindNodeSession(sessionId string) (*NodeSession, bool) {
@@ -35,39 +168,178 @@ func (s *Cache) FindNodeSession(sessionId string) (*NodeSession, bool) {
 }
 
 func (s *Cache) FindUISession(sessionId string) (*UISession, bool) {
-	return s.UISessions.Get(sessionId)
+	session, ok := s.UISessions.Get(sessionId)
+
+	if !ok {
+		return nil, false
+	}
+
+	if session.expired(time.Now()) {
+		s.UISessions.Remove(sessionId)
+		return nil, false
+	}
+
+	session.touch()
+
+	return session, true
 }
 
 func (s *Cache) CreateNodeSession(node *NodeInfo) (*NodeSession, error) {
+	if s.store != nil {
+		if err := s.store.saveNode(node, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.addNodeSession(node), nil
+}
+
+func (s *Cache) addNodeSession(node *NodeInfo) *NodeSession {
 	requestCh := make(chan *erigon_node.NodeRequest)
 
 	nodeSession := &NodeSession{
-		RequestCh:    requestCh,
-		Client:       erigon_node.NewClient(node.Id, requestCh),
-		SessionCache: s,
-		NodeInfo:     node,
+		RequestCh:      requestCh,
+		Client:         erigon_node.NewClient(node.Id, requestCh),
+		SessionCache:   s,
+		NodeInfo:       node,
+		disconnectedAt: time.Now(),
 	}
 
 	s.NodeSessions.Add(node.Id, nodeSession)
-	return nodeSession, nil
+	return nodeSession
 }
 
-func NewCache(maxNodeSessions int, maxUISessions int) (CacheService, error) {
+// mapUINode records the node as attached to the UI session, so it is attached
+// to the UI session when that is created
+func (s *Cache) mapUINode(session string, node *NodeSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	nodes, ok := s.uiNodeMap[session]
+
+	if !ok {
+		nodes = map[string]*NodeSession{}
+		s.uiNodeMap[session] = nodes
+	}
+
+	nodes[node.NodeInfo.Id] = node
+}
+
+func (s *Cache) Close() error {
+	if s.stopExpiry != nil {
+		close(s.stopExpiry)
+		s.stopExpiry = nil
+	}
+
+	if s.store != nil {
+		return s.store.close()
+	}
+
+	return nil
+}
+
+// startExpiry periodically removes the sessions which outlived their TTL,
+// the eviction callbacks then clean up after them as they do for the LRU
+func (s *Cache) startExpiry() {
+	interval := maxExpiryInterval
+
+	for _, ttl := range []time.Duration{s.uiSessionLifetime, s.uiSessionTTL, s.nodeSessionTTL} {
+		if ttl > 0 {
+			interval = min(interval, ttl/2)
+		}
+	}
+
+	if s.uiSessionLifetime <= 0 && s.uiSessionTTL <= 0 && s.nodeSessionTTL <= 0 {
+		return
+	}
+
+	s.stopExpiry = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(max(interval, time.Second))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				s.expire(now)
+			}
+		}
+	}(s.stopExpiry)
+}
+
+func (s *Cache) expire(now time.Time) {
+	for _, key := range s.UISessions.Keys() {
+		if session, ok := s.UISessions.Peek(key); ok {
+			if session.expired(now) || (s.uiSessionTTL > 0 && session.idleFor(now) > s.uiSessionTTL) {
+				s.UISessions.Remove(key)
+			}
+		}
+	}
+
+	if s.nodeSessionTTL > 0 {
+		for _, key := range s.NodeSessions.Keys() {
+			if session, ok := s.NodeSessions.Peek(key); ok {
+				if disconnected, ok := session.disconnectedFor(now); ok && disconnected > s.nodeSessionTTL {
+					s.NodeSessions.Remove(key)
+				}
+			}
+		}
+	}
+}
 
-	uis, err := lru.New[string, *UISession](maxUISessions)
+func NewCache(maxNodeSessions int, maxUISessions int, options ...CacheOption) (CacheService, error) {
+	cache, err := newCache(maxNodeSessions, maxUISessions, options...)
 
 	if err != nil {
 		return nil, err
 	}
 
+	cache.startExpiry()
+
+	return cache, nil
+}
+
+func newCache(maxNodeSessions int, maxUISessions int, options ...CacheOption) (*Cache, error) {
 	cache := &Cache{
-		UISessions: uis,
-		uiNodeMap:  map[string]map[string]*NodeSession{},
+		uiNodeMap:     map[string]map[string]*NodeSession{},
+		pins:          securePins,
+		maxUISessions: maxUISessions,
+	}
+
+	for _, option := range options {
+		option(cache)
+	}
+
+	var err error
+
+	cache.UISessions, err = lru.NewWithEvict[string, *UISession](maxUISessions, func(key string, value *UISession) {
+		cache.lock.Lock()
+		nodes := cache.uiNodeMap[key]
+		delete(cache.uiNodeMap, key)
+		cache.lock.Unlock()
+
+		for _, node := range nodes {
+			node.detachSession(key)
+		}
+
+		if cache.store != nil {
+			if err := cache.store.deleteUISession(key); err != nil {
+				log.Printf("Error deleting UI session %s: %v\n", key, err)
+			}
+		}
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
 	cache.NodeSessions, err = lru.NewWithEvict[string, *NodeSession](maxNodeSessions, func(key string, value *NodeSession) {
 
-		for _, session := range value.UISessions {
+		for _, session := range value.uiSessions() {
+			cache.lock.Lock()
 			if nodes, ok := cache.uiNodeMap[session]; ok {
 				delete(nodes, key)
 
@@ -75,11 +347,18 @@ func NewCache(maxNodeSessions int, maxUISessions int) (CacheService, error) {
 					delete(cache.uiNodeMap, session)
 				}
 			}
+			cache.lock.Unlock()
 
-			if uiSession, ok := cache.UISessions.Get(session); ok {
+			if uiSession, ok := cache.UISessions.Peek(session); ok {
 				uiSession.Detach(value.NodeInfo.Id)
 			}
 		}
+
+		if cache.store != nil {
+			if err := cache.store.deleteNode(key); err != nil {
+				log.Printf("Error deleting node session %s: %v\n", key, err)
+			}
+		}
 	})
 
 	if err != nil {
@@ -96,6 +375,12 @@ type CacheService interface {
 	FindUISession(sessionId string) (*UISession, bool)
 	// AllocateNewNodeSession creates a new node session and inserts it in to the cache
 	CreateNodeSession(node *NodeInfo) (*NodeSession, error)
-	// AddUISession inserts in to the cache the specified UI session
-	CreateUISession(sessionId string) (*UISession, error)
+	// IssueUISession creates a UI session with a new server generated PIN
+	IssueUISession() (*UISession, error)
+	// RevokeUISession removes the UI session, returning false if there was none
+	RevokeUISession(sessionId string) bool
+	// VerifyBridgeHandshake checks the response of a node connecting over the bridge to its challenge
+	VerifyBridgeHandshake(nonce []byte, sessionIds []string, nodes []*NodeInfo, response HandshakeResponse) error
+	// Close releases the resources held by the cache, such as its on-disk store
+	Close() error
 }
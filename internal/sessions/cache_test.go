@@ -0,0 +1,191 @@
+package sessions
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSession adds the UI session to the cache, failing the test unless it is created
+func newTestSession(t *testing.T, cache *Cache, sessionId string) *UISession {
+	t.Helper()
+
+	session, created, err := cache.createUISession(sessionId, time.Time{}, nil)
+
+	if err != nil || !created {
+		t.Fatalf("creating session %s: %v", sessionId, err)
+	}
+
+	return session
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cache, err := newCache(10, 10, WithUISessionTTL(time.Minute), WithNodeSessionTTL(time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idle := newTestSession(t, cache, sessionA)
+	inUse := newTestSession(t, cache, sessionB)
+	node := cache.addNodeSession(&NodeInfo{Id: "node"})
+
+	if err := node.AttachSessions([]string{sessionA, sessionB}); err != nil {
+		t.Fatal(err)
+	}
+
+	mapped := func(session string) bool {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		_, ok := cache.uiNodeMap[session]["node"]
+		return ok
+	}
+
+	// seen sets when the session was last used
+	seen := func(session *UISession, at time.Time) {
+		session.lock.Lock()
+		defer session.lock.Unlock()
+		session.lastSeen = at
+	}
+
+	now := time.Now()
+
+	t.Run("idle UI session", func(t *testing.T) {
+		cache.expire(now.Add(30 * time.Second))
+
+		if cache.UISessions.Len() != 2 {
+			t.Fatalf("sessions %v expired before their TTL", cache.UISessions.Keys())
+		}
+
+		seen(idle, now.Add(-2*time.Minute))
+
+		cache.expire(now.Add(30 * time.Second))
+
+		if _, ok := cache.UISessions.Peek(sessionA); ok {
+			t.Fatal("idle session did not expire")
+		}
+
+		if _, ok := cache.UISessions.Peek(sessionB); !ok {
+			t.Fatal("session in use expired")
+		}
+
+		if mapped(sessionA) || node.HasUISession(sessionA) {
+			t.Fatal("node is still attached to the expired session")
+		}
+
+		if !mapped(sessionB) || !node.HasUISession(sessionB) || !inUse.IsActive() {
+			t.Fatal("node was detached from the session in use")
+		}
+	})
+
+	t.Run("disconnected node", func(t *testing.T) {
+		seen(inUse, now.Add(30*time.Minute))
+		cache.expire(now.Add(30 * time.Minute))
+
+		if _, ok := cache.NodeSessions.Peek("node"); !ok {
+			t.Fatal("node expired before its TTL")
+		}
+
+		seen(inUse, now.Add(2*time.Hour))
+		cache.expire(now.Add(2 * time.Hour))
+
+		if _, ok := cache.UISessions.Peek(sessionB); !ok {
+			t.Fatal("session in use expired")
+		}
+
+		if _, ok := cache.NodeSessions.Peek("node"); ok {
+			t.Fatal("disconnected node did not expire")
+		}
+
+		if mapped(sessionB) || inUse.IsActive() || len(inUse.NodeSessions()) != 0 {
+			t.Fatalf("expired node is still attached to the session: %v", inUse.NodeSessions())
+		}
+
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+
+		if len(cache.uiNodeMap) != 0 {
+			t.Fatalf("node map is left with %v", cache.uiNodeMap)
+		}
+	})
+}
+
+func TestCacheRemovesNodeMapEntries(t *testing.T) {
+	cache, err := newCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := newTestSession(t, cache, sessionA)
+
+	for _, id := range []string{"b", "a"} {
+		if err := cache.addNodeSession(&NodeInfo{Id: id}).AttachSessions([]string{sessionA, sessionB}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if nodes := session.NodeSessions(); len(nodes) != 2 || nodes[0].NodeInfo.Id != "a" || nodes[1].NodeInfo.Id != "b" {
+		t.Fatalf("session has nodes %v", nodes)
+	}
+
+	cache.NodeSessions.Remove("a")
+
+	if nodes := session.NodeSessions(); len(nodes) != 1 || nodes[0].NodeInfo.Id != "b" {
+		t.Fatalf("session has nodes %v after removing a", nodes)
+	}
+
+	cache.UISessions.Remove(sessionA)
+
+	if node, _ := cache.NodeSessions.Peek("b"); node.HasUISession(sessionA) || !node.HasUISession(sessionB) {
+		t.Fatalf("node b is attached to %v after removing session A", node.uiSessions())
+	}
+
+	// a session created later picks up the nodes still mapped to it
+	if nodes := newTestSession(t, cache, sessionB).NodeSessions(); len(nodes) != 1 || nodes[0].NodeInfo.Id != "b" {
+		t.Fatalf("session B has nodes %v", nodes)
+	}
+
+	cache.NodeSessions.Remove("b")
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if len(cache.uiNodeMap) != 0 {
+		t.Fatalf("node map is left with %v", cache.uiNodeMap)
+	}
+}
+
+// TestUISessionNodesRace reads the nodes of a session while they are detached
+// by the background expiry, run with -race
+func TestUISessionNodesRace(t *testing.T) {
+	cache, err := newCache(100, 10, WithNodeSessionTTL(time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := newTestSession(t, cache, sessionA)
+
+	for i := 0; i < 50; i++ {
+		if err := cache.addNodeSession(&NodeInfo{Id: string(rune('a' + i))}).AttachSessions([]string{sessionA}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		cache.expire(time.Now().Add(time.Hour))
+	}()
+
+	for session.IsActive() {
+		for _, node := range session.NodeSessions() {
+			_ = node.IsConnected()
+		}
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,204 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Types of the messages the server sends during the bridge handshake
+const (
+	HandshakeChallenge = "challenge"
+	HandshakeAccepted  = "accepted"
+	HandshakeRejected  = "rejected"
+)
+
+// Versions of the bridge protocol
+const (
+	BridgeProtocolV1 uint64 = 1 // nodes do not advertise the methods they support
+	BridgeProtocolV2 uint64 = 2 // nodes list the methods they support in the handshake
+	BridgeProtocolV3 uint64 = 3 // nodes may send results in binary chunks, see erigon_node.ChunkHeader
+)
+
+// SupportedBridgeVersions are advertised in the challenge, newest first
+var SupportedBridgeVersions = []uint64{BridgeProtocolV3, BridgeProtocolV2, BridgeProtocolV1}
+
+const (
+	bridgeNonceLength  = 32
+	bridgeSecretLength = 32
+)
+
+var ErrHandshakeRejected = errors.New("bridge handshake rejected")
+
+// HandshakeMessage is sent by the server while a node connects over the bridge: first
+// a challenge with a fresh nonce and the protocol versions the server supports, then
+// whether the node's response was accepted, the version agreed on and, from BridgeProtocolV2
+// on, the token to resume the connection with if it is lost
+type HandshakeMessage struct {
+	Type        string   `json:"type"`
+	Nonce       []byte   `json:"nonce,omitempty"`
+	Versions    []uint64 `json:"versions,omitempty"`
+	Version     uint64   `json:"version,omitempty"`
+	ResumeToken string   `json:"resumeToken,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// HandshakeResponse answers the challenge with an HMAC-SHA256, keyed with the (hex decoded) bridge secret
+// of each session the node attaches to, over the challenge payload. A node which has pinned
+// a public key also signs the payload with its ed25519 key
+type HandshakeResponse struct {
+	Macs      map[string][]byte `json:"macs"` // session id -> mac
+	PublicKey []byte            `json:"publicKey,omitempty"`
+	Signature []byte            `json:"signature,omitempty"`
+	// Version is the protocol version the node chose, Capabilities the methods
+	// it supports from BridgeProtocolV2 on, such as "headers_download" or "subscribe/*"
+	Version      uint64   `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// ResumeToken was issued when the node's previous connection was accepted,
+	// to resume that connection's requests after it was lost
+	ResumeToken string `json:"resumeToken,omitempty"`
+}
+
+// NewBridgeChallenge returns a challenge with a random nonce, it must only be used for one connection
+func NewBridgeChallenge() (HandshakeMessage, error) {
+	nonce := make([]byte, bridgeNonceLength)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return HandshakeMessage{}, fmt.Errorf("generating bridge nonce: %w", err)
+	}
+
+	return HandshakeMessage{Type: HandshakeChallenge, Nonce: nonce, Versions: SupportedBridgeVersions}, nil
+}
+
+// NegotiateBridgeVersion returns the version the node chose in its response, or else the one it
+// announced when connecting. Nodes which announce neither are taken to speak BridgeProtocolV1
+func NegotiateBridgeVersion(announced uint64, response HandshakeResponse) (uint64, error) {
+	version := response.Version
+
+	if version == 0 {
+		version = announced
+	}
+
+	if version == 0 {
+		version = BridgeProtocolV1
+	}
+
+	if !slices.Contains(SupportedBridgeVersions, version) {
+		return 0, fmt.Errorf("%w: unsupported protocol version %d", ErrHandshakeRejected, version)
+	}
+
+	return version, nil
+}
+
+func newBridgeSecret() ([]byte, error) {
+	secret := make([]byte, bridgeSecretLength)
+
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating bridge secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// HandshakePayload is what a node authenticates: the nonce of the connection together with
+// the sessions and nodes it claims, so a response can not be used for other sessions or nodes
+func HandshakePayload(nonce []byte, sessionIds []string, nodeIds []string) []byte {
+	sessionIds = slices.Clone(sessionIds)
+	slices.Sort(sessionIds)
+	nodeIds = slices.Clone(nodeIds)
+	slices.Sort(nodeIds)
+
+	return []byte(strings.Join([]string{
+		"diagnostics-bridge-v1",
+		base64.StdEncoding.EncodeToString(nonce),
+		strings.Join(sessionIds, ","),
+		strings.Join(nodeIds, ","),
+	}, "\n"))
+}
+
+// HandshakeMac returns the mac of the payload for a session with the given bridge secret
+func HandshakeMac(secret []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// VerifyBridgeHandshake checks the response of a node to the challenge nonce: every session it
+// attaches to must have been issued and be answered with a valid mac, and any node which has
+// pinned a public key must present it with a valid signature
+func (s *Cache) VerifyBridgeHandshake(nonce []byte, sessionIds []string, nodes []*NodeInfo, response HandshakeResponse) error {
+	nodeIds := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		nodeIds = append(nodeIds, node.Id)
+	}
+
+	payload := HandshakePayload(nonce, sessionIds, nodeIds)
+
+	if len(sessionIds) == 0 {
+		return fmt.Errorf("%w: no sessions to attach to", ErrHandshakeRejected)
+	}
+
+	if len(response.Macs) == 0 {
+		// as from nodes which attach with a PIN alone, from before the handshake
+		return fmt.Errorf("%w: no macs in the response", ErrHandshakeRejected)
+	}
+
+	for _, sessionId := range sessionIds {
+		session, ok := s.UISessions.Peek(sessionId)
+
+		if !ok || session.expired(time.Now()) {
+			return fmt.Errorf("%w: unknown session %s", ErrHandshakeRejected, sessionId)
+		}
+
+		if len(session.BridgeSecret) == 0 || !hmac.Equal(response.Macs[sessionId], HandshakeMac(session.BridgeSecret, payload)) {
+			return fmt.Errorf("%w: invalid mac for session %s", ErrHandshakeRejected, sessionId)
+		}
+	}
+
+	if len(response.PublicKey) > 0 || len(response.Signature) > 0 {
+		if len(response.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(response.PublicKey, payload, response.Signature) {
+			return fmt.Errorf("%w: invalid signature", ErrHandshakeRejected)
+		}
+	}
+
+	for _, nodeId := range nodeIds {
+		node, ok := s.NodeSessions.Peek(nodeId)
+
+		if !ok {
+			continue
+		}
+
+		if pinned := node.pinnedKey(); pinned != nil {
+			if !bytes.Equal(pinned, response.PublicKey) {
+				return fmt.Errorf("%w: node %s is pinned to another public key", ErrHandshakeRejected, nodeId)
+			}
+
+			continue
+		}
+
+		// without a pinned key, taking over a known node requires the secret of one of its live sessions
+		var live, proven bool
+
+		for _, attached := range node.uiSessions() {
+			if session, ok := s.UISessions.Peek(attached); ok && !session.expired(time.Now()) {
+				live = true
+				proven = proven || slices.Contains(sessionIds, attached)
+			}
+		}
+
+		if live && !proven {
+			return fmt.Errorf("%w: node %s is attached to other sessions", ErrHandshakeRejected, nodeId)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package sessions
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+// handshakeFixture is a cache with the sessions A and B, the node "pinned" pinned to
+// pinnedKey and attached to A, and the node "attached" attached to B
+type handshakeFixture struct {
+	cache     *Cache
+	nonce     []byte
+	secrets   map[string][]byte
+	pinnedKey ed25519.PrivateKey
+	otherKey  ed25519.PrivateKey
+}
+
+func newHandshakeFixture(t *testing.T) *handshakeFixture {
+	cache, err := newCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &handshakeFixture{cache: cache, secrets: map[string][]byte{}}
+
+	for _, id := range []string{sessionA, sessionB} {
+		secret, err := newBridgeSecret()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := cache.createUISession(id, time.Time{}, secret); err != nil {
+			t.Fatal(err)
+		}
+
+		f.secrets[id] = secret
+	}
+
+	challenge, err := NewBridgeChallenge()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.nonce = challenge.Nonce
+
+	if _, f.pinnedKey, err = ed25519.GenerateKey(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, f.otherKey, err = ed25519.GenerateKey(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, session := range map[string]string{"pinned": sessionA, "attached": sessionB} {
+		node := cache.addNodeSession(&NodeInfo{Id: id})
+
+		if err := node.AttachSessions([]string{session}); err != nil {
+			t.Fatal(err)
+		}
+
+		if id == "pinned" {
+			if err := node.PinPublicKey(f.pinnedKey.Public().(ed25519.PublicKey)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return f
+}
+
+// respond answers the challenge nonce for the sessions and nodes, with the macs
+// of the given secrets and a signature when a key is given
+func respond(nonce []byte, sessionIds []string, nodeIds []string, secrets map[string][]byte, key ed25519.PrivateKey) HandshakeResponse {
+	payload := HandshakePayload(nonce, sessionIds, nodeIds)
+	response := HandshakeResponse{Macs: map[string][]byte{}}
+
+	for id, secret := range secrets {
+		response.Macs[id] = HandshakeMac(secret, payload)
+	}
+
+	if key != nil {
+		response.PublicKey = key.Public().(ed25519.PublicKey)
+		response.Signature = ed25519.Sign(key, payload)
+	}
+
+	return response
+}
+
+func TestVerifyBridgeHandshake(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		sessionIds []string
+		nodeIds    []string
+		response   func(f *handshakeFixture) HandshakeResponse
+		wantErr    bool
+	}{
+		{
+			name:       "valid mac",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"new"}, f.secrets, nil)
+			},
+		},
+		{
+			name:       "several sessions and nodes",
+			sessionIds: []string{sessionA, sessionB},
+			nodeIds:    []string{"new", "attached"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA, sessionB}, []string{"new", "attached"}, f.secrets, nil)
+			},
+		},
+		{
+			name:       "ids in another order",
+			sessionIds: []string{sessionA, sessionB},
+			nodeIds:    []string{"new", "attached"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionB, sessionA}, []string{"attached", "new"}, f.secrets, nil)
+			},
+		},
+		{
+			name:       "wrong secret",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"new"}, map[string][]byte{sessionA: f.secrets[sessionB]}, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "no mac",
+			sessionIds: []string{sessionA, sessionB},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA, sessionB}, []string{"new"}, map[string][]byte{sessionA: f.secrets[sessionA]}, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "no macs, as from a node attaching with a PIN alone",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return HandshakeResponse{}
+			},
+			wantErr: true,
+		},
+		{
+			name:       "mac for other sessions",
+			sessionIds: []string{sessionA, sessionB},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"new"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "mac for another node",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"other"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "replayed nonce",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				previous, _ := NewBridgeChallenge()
+				return respond(previous.Nonce, []string{sessionA}, []string{"new"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "unknown session",
+			sessionIds: []string{sessionA, unknownSession},
+			nodeIds:    []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA, unknownSession}, []string{"new"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no sessions",
+			nodeIds: []string{"new"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, nil, []string{"new"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "pinned key",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"pinned"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"pinned"}, f.secrets, f.pinnedKey)
+			},
+		},
+		{
+			name:       "pinned key mismatch",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"pinned"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"pinned"}, f.secrets, f.otherKey)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "pinned key missing",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"pinned"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"pinned"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "invalid signature",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"pinned"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				response := respond(f.nonce, []string{sessionA}, []string{"pinned"}, f.secrets, f.pinnedKey)
+				response.Signature = ed25519.Sign(f.pinnedKey, []byte("something else"))
+				return response
+			},
+			wantErr: true,
+		},
+		{
+			name:       "node attached to other sessions",
+			sessionIds: []string{sessionA},
+			nodeIds:    []string{"attached"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionA}, []string{"attached"}, f.secrets, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "node attached to the session",
+			sessionIds: []string{sessionB},
+			nodeIds:    []string{"attached"},
+			response: func(f *handshakeFixture) HandshakeResponse {
+				return respond(f.nonce, []string{sessionB}, []string{"attached"}, f.secrets, nil)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHandshakeFixture(t)
+
+			nodes := make([]*NodeInfo, len(tt.nodeIds))
+
+			for i, id := range tt.nodeIds {
+				nodes[i] = &NodeInfo{Id: id}
+			}
+
+			err := f.cache.VerifyBridgeHandshake(f.nonce, tt.sessionIds, nodes, tt.response(f))
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrHandshakeRejected) {
+					t.Fatalf("got %v, want the handshake rejected", err)
+				}
+			} else if err != nil {
+				t.Fatalf("handshake rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandshakePayload(t *testing.T) {
+	nonce := []byte("nonce")
+	payload := HandshakePayload(nonce, []string{"b", "a"}, []string{"2", "1"})
+
+	for _, tt := range []struct {
+		name       string
+		nonce      []byte
+		sessionIds []string
+		nodeIds    []string
+		same       bool
+	}{
+		{"same order", nonce, []string{"b", "a"}, []string{"2", "1"}, true},
+		{"sorted", nonce, []string{"a", "b"}, []string{"1", "2"}, true},
+		{"other nonce", []byte("nonce2"), []string{"a", "b"}, []string{"1", "2"}, false},
+		{"fewer sessions", nonce, []string{"a"}, []string{"1", "2"}, false},
+		{"sessions as nodes", nonce, []string{"1", "2"}, []string{"a", "b"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := string(HandshakePayload(tt.nonce, tt.sessionIds, tt.nodeIds)) == string(payload); same != tt.same {
+				t.Fatalf("payloads equal = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
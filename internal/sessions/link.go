@@ -0,0 +1,99 @@
+package sessions
+
+import (
+	"time"
+)
+
+// linkHistoryLength is the number of pings kept in the link history of a node
+const linkHistoryLength = 60
+
+// LinkQuality describes the bridge connection to a node, from the pings the server sends it, so
+// a slow link can be told apart from a slow node. Round trip times are in milliseconds
+type LinkQuality struct {
+	LastSeen    *time.Time   `json:"last_seen,omitempty"` // when a message was last received from the node, nil if never
+	RTT         float64      `json:"rtt_ms,omitempty"`    // of the latest ping
+	AverageRTT  float64      `json:"average_rtt_ms,omitempty"`
+	LostPings   int          `json:"lost_pings"`  // in the history
+	Connections uint64       `json:"connections"` // bridge connections made by the node, including resumptions
+	History     []LinkSample `json:"history"`     // oldest first
+}
+
+// LinkSample is the outcome of one ping, Lost when no pong came back before the next ping
+type LinkSample struct {
+	Time time.Time `json:"time"`
+	RTT  float64   `json:"rtt_ms,omitempty"`
+	Lost bool      `json:"lost,omitempty"`
+}
+
+// Seen records a message received from the node
+func (ns *NodeSession) Seen(at time.Time) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if at.After(ns.lastSeen) {
+		ns.lastSeen = at
+	}
+}
+
+// RecordPing records the round trip time of a ping answered by the node, or a lost ping
+func (ns *NodeSession) RecordPing(at time.Time, rtt time.Duration, lost bool) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	sample := LinkSample{Time: at, Lost: lost}
+
+	if !lost {
+		sample.RTT = milliseconds(rtt)
+
+		if at.After(ns.lastSeen) {
+			ns.lastSeen = at
+		}
+	}
+
+	if len(ns.linkHistory) == linkHistoryLength {
+		ns.linkHistory = append(ns.linkHistory[:0], ns.linkHistory[1:]...)
+	}
+
+	ns.linkHistory = append(ns.linkHistory, sample)
+}
+
+// LinkQuality returns the quality of the bridge connection to the node
+func (ns *NodeSession) LinkQuality() LinkQuality {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	quality := LinkQuality{
+		Connections: ns.connection,
+		History:     make([]LinkSample, len(ns.linkHistory)),
+	}
+
+	if !ns.lastSeen.IsZero() {
+		lastSeen := ns.lastSeen
+		quality.LastSeen = &lastSeen
+	}
+
+	copy(quality.History, ns.linkHistory)
+
+	var answered int
+
+	for _, sample := range quality.History {
+		if sample.Lost {
+			quality.LostPings++
+			continue
+		}
+
+		quality.RTT = sample.RTT
+		quality.AverageRTT += sample.RTT
+		answered++
+	}
+
+	if answered > 0 {
+		quality.AverageRTT /= float64(answered)
+	}
+
+	return quality
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,52 @@
+package sessions
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkQuality(t *testing.T) {
+	cache, err := newCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := cache.addNodeSession(&NodeInfo{Id: "node"})
+	encoded, err := json.Marshal(node.LinkQuality())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(encoded), "last_seen") {
+		t.Fatalf("node which was never seen got %s", encoded)
+	}
+
+	now := time.Now()
+	node.Seen(now)
+	node.RecordPing(now.Add(time.Second), 10*time.Millisecond, false)
+	node.RecordPing(now.Add(2*time.Second), 0, true)
+	node.RecordPing(now.Add(3*time.Second), 30*time.Millisecond, false)
+	node.Seen(now) // seen earlier than the last pong
+
+	quality := node.LinkQuality()
+
+	if quality.LastSeen == nil || !quality.LastSeen.Equal(now.Add(3*time.Second)) {
+		t.Fatalf("last seen at %v", quality.LastSeen)
+	}
+
+	if quality.RTT != 30 || quality.AverageRTT != 20 || quality.LostPings != 1 || len(quality.History) != 3 {
+		t.Fatalf("got %+v", quality)
+	}
+
+	for i := 0; i < linkHistoryLength; i++ {
+		node.RecordPing(now.Add(time.Duration(4+i)*time.Second), time.Millisecond, false)
+	}
+
+	if quality := node.LinkQuality(); len(quality.History) != linkHistoryLength || quality.LostPings != 0 || quality.AverageRTT != 1 {
+		t.Fatalf("after a full history got %+v", quality)
+	}
+}
@@ -1,16 +1,85 @@
 package sessions
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 )
 
 type Middleware struct {
 	CacheService
+	Tokens *TokenSigner
 }
 
+// Middleware only lets requests for a session through with a valid token of that
+// session, taken from the Authorization header or else the session's cookie
 func (s *Middleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO this needs to validate the that the session id in the URL is accessible
+		sessionId, ok := sessionIdFromPath(r.URL.Path)
+
+		if !ok {
+			// routes outside of a session, such as login or issuing a session
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := requestToken(r, sessionId)
+
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "session token is required", http.StatusUnauthorized)
+			return
+		}
+
+		tokenSession, err := s.Tokens.Verify(token)
+
+		if err != nil {
+			if errors.Is(err, ErrExpiredToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="expired"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if tokenSession != sessionId {
+			http.Error(w, "session token does not grant access to session "+sessionId, http.StatusForbidden)
+			return
+		}
+
+		if _, ok := s.FindUISession(sessionId); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "session has expired or been revoked", http.StatusUnauthorized)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// sessionIdFromPath returns the session id following the sessions segment of the path
+func sessionIdFromPath(path string) (string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, segment := range segments[:len(segments)-1] {
+		if segment == "sessions" {
+			return segments[i+1], true
+		}
+	}
+
+	return "", false
+}
+
+func requestToken(r *http.Request, sessionId string) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+
+	if cookie, err := r.Cookie(TokenCookie(sessionId)); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	cache, err := newCache(10, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{sessionA, sessionB} {
+		if _, _, err := cache.createUISession(id, time.Time{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signer, _ := NewTokenSigner([]byte("secret"), time.Hour)
+	otherSigner, _ := NewTokenSigner([]byte("other secret"), time.Hour)
+	expiredSigner, _ := NewTokenSigner([]byte("secret"), -time.Second)
+
+	token := mustIssue(signer, sessionA)
+	revokedToken := mustIssue(signer, sessionB)
+	cache.RevokeUISession(sessionB)
+
+	middleware := &Middleware{CacheService: cache, Tokens: signer}
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tt := range []struct {
+		name         string
+		path         string
+		header       string
+		cookie       string
+		wantStatus   int
+		wantAuthHint string // in WWW-Authenticate
+	}{
+		{name: "outside of a session", path: "/api/sessions", wantStatus: http.StatusNoContent},
+		{name: "valid header", path: "/api/sessions/" + sessionA + "/nodes", header: "Bearer " + token, wantStatus: http.StatusNoContent},
+		{name: "valid cookie", path: "/api/sessions/" + sessionA, cookie: token, wantStatus: http.StatusNoContent},
+		{name: "no token", path: "/api/sessions/" + sessionA, wantStatus: http.StatusUnauthorized, wantAuthHint: "Bearer"},
+		{name: "not a bearer token", path: "/api/sessions/" + sessionA, header: "Basic " + token, wantStatus: http.StatusUnauthorized, wantAuthHint: "Bearer"},
+		{name: "expired", path: "/api/sessions/" + sessionA, header: "Bearer " + mustIssue(expiredSigner, sessionA), wantStatus: http.StatusUnauthorized, wantAuthHint: `error_description="expired"`},
+		{name: "expired cookie", path: "/api/sessions/" + sessionA, cookie: mustIssue(expiredSigner, sessionA), wantStatus: http.StatusUnauthorized, wantAuthHint: `error_description="expired"`},
+		{name: "tampered", path: "/api/sessions/" + sessionA, header: "Bearer " + token + "x", wantStatus: http.StatusUnauthorized, wantAuthHint: `error="invalid_token"`},
+		{name: "other secret", path: "/api/sessions/" + sessionA, header: "Bearer " + mustIssue(otherSigner, sessionA), wantStatus: http.StatusUnauthorized, wantAuthHint: `error="invalid_token"`},
+		{name: "wrong session", path: "/api/sessions/" + sessionB, header: "Bearer " + token, wantStatus: http.StatusForbidden},
+		{name: "unknown session", path: "/api/sessions/" + unknownSession, header: "Bearer " + mustIssue(signer, unknownSession), wantStatus: http.StatusUnauthorized, wantAuthHint: `error="invalid_token"`},
+		{name: "revoked session", path: "/api/sessions/" + sessionB, header: "Bearer " + revokedToken, wantStatus: http.StatusUnauthorized, wantAuthHint: `error="invalid_token"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: TokenCookie(sessionA), Value: tt.cookie})
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if auth := w.Header().Get("WWW-Authenticate"); !strings.Contains(auth, tt.wantAuthHint) || (tt.wantAuthHint == "") != (auth == "") {
+				t.Fatalf("WWW-Authenticate is %q, want %q", auth, tt.wantAuthHint)
+			}
+		})
+	}
+}
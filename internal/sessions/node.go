@@ -1,8 +1,11 @@
 package sessions
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/erigontech/diagnostics/internal/erigon_node"
 )
@@ -36,19 +39,102 @@ type NodeSession struct {
 	UISessions   []string
 	SessionCache *Cache
 	NodeInfo     *NodeInfo
+
+	disconnectedAt time.Time         // when the node was last seen connected, for expiring its session
+	publicKey      ed25519.PublicKey // once pinned the node must sign its bridge handshakes with this key
+
+	protocolVersion uint64
+	capabilities    []string
+
+	connection  uint64                     // counts the bridge connections, so a lost one can not disconnect a later one
+	closeBridge func()                     // closes the current bridge connection
+	lost        chan struct{}              // closed once the current bridge connection is lost
+	resumeToken string                     // resumes the current or suspended bridge connection
+	resumeTimer *time.Timer                // expires a suspended bridge connection
+	parked      []*erigon_node.NodeRequest // in flight when the suspended bridge connection was lost
+
+	lastSeen    time.Time
+	linkHistory []LinkSample
+}
+
+func (ns *NodeSession) IsConnected() bool {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	return ns.Connected
+}
+
+// disconnectedFor returns how long the node has been disconnected, false while it is connected
+func (ns *NodeSession) disconnectedFor(now time.Time) (time.Duration, bool) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if ns.Connected {
+		return 0, false
+	}
+
+	return now.Sub(ns.disconnectedAt), true
+}
+
+func (ns *NodeSession) detachSession(session string) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	ns.UISessions = slices.DeleteFunc(ns.UISessions, func(s string) bool { return s == session })
+}
+
+// SetProtocol records the bridge protocol version agreed with the node and the methods it
+// supports, which are nil when the version does not advertise them
+func (ns *NodeSession) SetProtocol(version uint64, capabilities []string) {
+	if version < BridgeProtocolV2 {
+		capabilities = nil
+	} else if capabilities == nil {
+		capabilities = []string{}
+	}
+
+	ns.lock.Lock()
+	ns.protocolVersion = version
+	ns.capabilities = capabilities
+	ns.lock.Unlock()
+
+	ns.Client.SetCapabilities(capabilities)
 }
 
-func (ns *NodeSession) Connect(remoteAddr string) {
+// Protocol returns the bridge protocol version of the node and the methods it supports
+func (ns *NodeSession) Protocol() (uint64, []string) {
 	ns.lock.Lock()
 	defer ns.lock.Unlock()
-	ns.Connected = true
-	ns.RemoteAddr = remoteAddr
+	return ns.protocolVersion, ns.capabilities
 }
 
-func (ns *NodeSession) Disconnect() {
+func (ns *NodeSession) pinnedKey() ed25519.PublicKey {
 	ns.lock.Lock()
 	defer ns.lock.Unlock()
-	ns.Connected = false
+	return ns.publicKey
+}
+
+// PinPublicKey requires the future bridge handshakes of the node to be signed with the given key
+func (ns *NodeSession) PinPublicKey(publicKey ed25519.PublicKey) error {
+	ns.lock.Lock()
+	ns.publicKey = publicKey
+	ns.lock.Unlock()
+
+	if store := ns.SessionCache.store; store != nil {
+		return store.saveNode(ns.NodeInfo, publicKey)
+	}
+
+	return nil
+}
+
+// HasUISession returns true when the node is attached to the given UI session
+func (ns *NodeSession) HasUISession(sessionId string) bool {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	return slices.Contains(ns.UISessions, sessionId)
+}
+
+func (ns *NodeSession) uiSessions() []string {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	return slices.Clone(ns.UISessions)
 }
 
 func NewNodeSession() NodeService {
@@ -57,17 +143,20 @@ func NewNodeSession() NodeService {
 
 func (ns *NodeSession) AttachSessions(sessions []string) error {
 	for _, session := range sessions {
-		ns.UISessions = append(ns.UISessions, session)
+		ns.lock.Lock()
+		if !slices.Contains(ns.UISessions, session) {
+			ns.UISessions = append(ns.UISessions, session)
+		}
+		ns.lock.Unlock()
 
-		nodes, ok := ns.SessionCache.uiNodeMap[session]
+		ns.SessionCache.mapUINode(session, ns)
 
-		if !ok {
-			nodes = map[string]*NodeSession{}
-			ns.SessionCache.uiNodeMap[session] = nodes
+		if store := ns.SessionCache.store; store != nil {
+			if err := store.saveAttachment(session, ns.NodeInfo.Id); err != nil {
+				return err
+			}
 		}
 
-		nodes[ns.NodeInfo.Id] = ns
-
 		if uiSession, ok := ns.SessionCache.UISessions.Get(session); ok {
 			uiSession.Attach(ns)
 		}
@@ -78,7 +167,7 @@ func (ns *NodeSession) AttachSessions(sessions []string) error {
 
 type NodeService interface {
 	// Connect sets the appropriate fields for connect
-	Connect(remoteAddr string)
+	Connect(remoteAddr string, resumeToken string, closeBridge func()) uint64
 	// Disconnect unsets the connect field
-	Disconnect()
+	Disconnect(connection uint64)
 }
@@ -0,0 +1,39 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+)
+
+// PinGenerator returns candidate PINs for new UI sessions, the cache checks them for collisions
+type PinGenerator func() (uint64, error)
+
+const (
+	minPin = 10_000_000 // PINs have 8 digits
+	maxPin = 99_999_999
+
+	// maxPinAttempts is how many PINs are tried before giving up on finding an unused one
+	maxPinAttempts = 16
+)
+
+// securePins generates uniformly random PINs with crypto/rand
+func securePins() (uint64, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxPin-minPin+1))
+
+	if err != nil {
+		return 0, fmt.Errorf("generating session pin: %w", err)
+	}
+
+	return minPin + n.Uint64(), nil
+}
+
+// insecurePins returns a generator of sequential PINs, which are predictable so only fit for testing
+func insecurePins() PinGenerator {
+	var next atomic.Uint64
+
+	return func() (uint64, error) {
+		return minPin + (next.Add(1)-1)%(maxPin-minPin+1), nil
+	}
+}
@@ -0,0 +1,175 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/erigontech/diagnostics/internal/erigon_node"
+)
+
+const (
+	resumeTokenLength = 32
+
+	// bridgeTakeoverTimeout bounds how long a resuming connection waits for the
+	// connection it replaces to close, when the server has not noticed it was lost
+	bridgeTakeoverTimeout = 10 * time.Second
+)
+
+// NewResumeToken returns a random token which the node presents in the handshake
+// of its next connection to resume the sessions of the current one
+func NewResumeToken() (string, error) {
+	token := make([]byte, resumeTokenLength)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("generating resume token: %w", err)
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// Connect marks the node connected over a new bridge connection, which the node can resume with
+// resumeToken once it is lost, and which closeBridge closes. Requests parked from a connection
+// which is not resumed fail. It returns the connection to suspend or disconnect
+func (ns *NodeSession) Connect(remoteAddr string, resumeToken string, closeBridge func()) uint64 {
+	ns.lock.Lock()
+	parked := ns.takeParked()
+	ns.connection++
+	ns.Connected = true
+	ns.RemoteAddr = remoteAddr
+	ns.lastSeen = time.Now()
+	ns.resumeToken = resumeToken
+	ns.closeBridge = closeBridge
+	ns.lost = make(chan struct{})
+	connection := ns.connection
+	ns.lock.Unlock()
+
+	ns.Client.SetConnected(true)
+	failRequests(parked)
+
+	return connection
+}
+
+// Disconnect marks the node disconnected when it was lost from the given connection, rather than
+// a later one, after which its requests fail with erigon_node.ErrNodeDisconnected
+func (ns *NodeSession) Disconnect(connection uint64) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if connection != ns.connection || !ns.Connected {
+		return
+	}
+
+	ns.Connected = false
+	ns.disconnectedAt = time.Now()
+	ns.resumeToken = ""
+	close(ns.lost)
+	ns.Client.SetConnected(false)
+}
+
+// Suspend marks the node disconnected from the given connection, keeping the requests which were in
+// flight to resend when the node resumes the connection within the resume grace. Requests made in the
+// meantime wait for the node to resume. It returns false, doing nothing, when the connection can not
+// be resumed or has been replaced
+func (ns *NodeSession) Suspend(connection uint64, inFlight []*erigon_node.NodeRequest) bool {
+	grace := ns.SessionCache.bridgeResumeGrace
+
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if connection != ns.connection || !ns.Connected || ns.resumeToken == "" || grace <= 0 {
+		return false
+	}
+
+	ns.Connected = false
+	ns.disconnectedAt = time.Now()
+	ns.parked = inFlight
+	ns.resumeTimer = time.AfterFunc(grace, func() { ns.expireResume(connection) })
+	close(ns.lost)
+
+	return true
+}
+
+// Resume returns the requests which were in flight when the connection issued the token was lost,
+// for the node to resend over its new connection. When the server has not yet noticed the connection
+// was lost it is closed first. It returns false when the token is unknown or its grace has expired
+func (ns *NodeSession) Resume(token string) ([]*erigon_node.NodeRequest, bool) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if token == "" || token != ns.resumeToken {
+		return nil, false
+	}
+
+	if ns.Connected {
+		closeBridge, lost := ns.closeBridge, ns.lost
+		ns.lock.Unlock()
+
+		if closeBridge != nil {
+			closeBridge()
+		}
+
+		select {
+		case <-lost:
+		case <-time.After(bridgeTakeoverTimeout):
+		}
+
+		ns.lock.Lock()
+
+		if ns.Connected || token != ns.resumeToken {
+			return nil, false
+		}
+	}
+
+	parked := ns.takeParked()
+	ns.resumeToken = ""
+
+	resumed := make([]*erigon_node.NodeRequest, 0, len(parked))
+
+	for _, request := range parked {
+		select {
+		case <-request.Done():
+			// nobody waits for it any more
+		default:
+			resumed = append(resumed, request)
+		}
+	}
+
+	return resumed, true
+}
+
+// expireResume disconnects the node if it has not resumed the connection by the end of its grace
+func (ns *NodeSession) expireResume(connection uint64) {
+	ns.lock.Lock()
+
+	if connection != ns.connection || ns.Connected || ns.resumeToken == "" {
+		ns.lock.Unlock()
+		return
+	}
+
+	parked := ns.takeParked()
+	ns.resumeToken = ""
+	ns.lock.Unlock()
+
+	ns.Client.SetConnected(false)
+	failRequests(parked)
+}
+
+// takeParked must be called with the lock held
+func (ns *NodeSession) takeParked() []*erigon_node.NodeRequest {
+	if ns.resumeTimer != nil {
+		ns.resumeTimer.Stop()
+		ns.resumeTimer = nil
+	}
+
+	parked := ns.parked
+	ns.parked = nil
+	return parked
+}
+
+func failRequests(requests []*erigon_node.NodeRequest) {
+	for _, request := range requests {
+		request.Fail(erigon_node.NodeDisconnectedError())
+	}
+}
@@ -0,0 +1,92 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid session token")
+	ErrExpiredToken = errors.New("session token has expired")
+)
+
+// TokenSigner issues and verifies the access tokens of UI sessions. A token is
+// the session id and its expiry, signed with an HMAC-SHA256 of the server's secret
+type TokenSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewTokenSigner returns a signer for tokens which are valid for ttl. Without a
+// secret a random one is used, so the tokens do not survive a restart
+func NewTokenSigner(secret []byte, ttl time.Duration) (*TokenSigner, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generating token secret: %w", err)
+		}
+	}
+
+	return &TokenSigner{secret: secret, ttl: ttl}, nil
+}
+
+// Issue returns a token granting access to the given session until the returned expiry
+func (s *TokenSigner) Issue(sessionId string) (string, time.Time) {
+	expires := time.Now().Add(s.ttl)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(sessionId + ":" + strconv.FormatInt(expires.Unix(), 10)))
+
+	return payload + "." + s.sign(payload), expires
+}
+
+// Verify checks the signature and expiry of the token and returns the session id it grants access to
+func (s *TokenSigner) Verify(token string) (string, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return "", ErrInvalidToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	sessionId, expiresStr, ok := strings.Cut(string(decoded), ":")
+
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= expires {
+		return "", ErrExpiredToken
+	}
+
+	return sessionId, nil
+}
+
+func (s *TokenSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TokenCookie is the name of the cookie holding the token of the given session, a
+// cookie per session lets a browser hold the tokens of several sessions at once
+func TokenCookie(sessionId string) string {
+	return "session_token_" + sessionId
+}
@@ -0,0 +1,66 @@
+package sessions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	sessionA       = "10000001"
+	sessionB       = "10000002"
+	unknownSession = "10000003"
+)
+
+func TestTokenSigner(t *testing.T) {
+	signer, err := NewTokenSigner([]byte("secret"), time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, expires := signer.Issue(sessionA)
+
+	if time.Until(expires) <= 0 || time.Until(expires) > time.Hour {
+		t.Fatalf("token expires at %v", expires)
+	}
+
+	otherSigner, _ := NewTokenSigner([]byte("other secret"), time.Hour)
+	expiredSigner, _ := NewTokenSigner([]byte("secret"), -time.Second)
+	payload, signature, _ := strings.Cut(token, ".")
+	otherPayload, _, _ := strings.Cut(mustIssue(signer, sessionB), ".")
+
+	for _, tt := range []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", token, nil},
+		{"expired", mustIssue(expiredSigner, sessionA), ErrExpiredToken},
+		{"other secret", mustIssue(otherSigner, sessionA), ErrInvalidToken},
+		{"tampered payload", otherPayload + "." + signature, ErrInvalidToken},
+		{"tampered signature", payload + "." + strings.ToUpper(signature), ErrInvalidToken},
+		{"no signature", payload, ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+		{"not base64", "!." + signer.sign("!"), ErrInvalidToken},
+		{"no expiry", "c2Vzc2lvbg." + signer.sign("c2Vzc2lvbg"), ErrInvalidToken},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionId, err := signer.Verify(tt.token)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify = %q, %v, want %v", sessionId, err, tt.wantErr)
+			}
+
+			if err == nil && sessionId != sessionA {
+				t.Fatalf("Verify = %q, want %q", sessionId, sessionA)
+			}
+		})
+	}
+}
+
+func mustIssue(signer *TokenSigner, sessionId string) string {
+	token, _ := signer.Issue(sessionId)
+	return token
+}
@@ -1,15 +1,23 @@
 package sessions
 
 import (
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type UISession struct {
 	lock       sync.Mutex
 	SessionPin uint64
 	Store      CacheService
-	Nodes      map[string]*NodeSession
+	Nodes      map[string]*NodeSession // guarded by lock, read it with NodeSessions
+	Expires    time.Time               // zero when the session does not expire
+	// BridgeSecret is shown to the operator, nodes use it to authenticate
+	// attaching to the session over the bridge
+	BridgeSecret []byte
+	lastSeen     time.Time
 }
 
 func (s *UISession) Attach(ns *NodeSession) {
@@ -24,8 +32,49 @@ func (s *UISession) Detach(nodeId string) {
 	delete(s.Nodes, nodeId)
 }
 
+// touch records the session being used, so it does not expire while it is in use
+func (s *UISession) touch() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastSeen = time.Now()
+}
+
+func (s *UISession) idleFor(now time.Time) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return now.Sub(s.lastSeen)
+}
+
+func (s *UISession) expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
 func (s *UISession) IsActive() bool {
-	return s != nil && len(s.Nodes) > 0
+	if s == nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.Nodes) > 0
+}
+
+// NodeSessions returns the node sessions attached to the session, ordered by node id
+func (s *UISession) NodeSessions() []*NodeSession {
+	s.lock.Lock()
+	nodes := make([]*NodeSession, 0, len(s.Nodes))
+
+	for _, node := range s.Nodes {
+		nodes = append(nodes, node)
+	}
+
+	s.lock.Unlock()
+
+	slices.SortFunc(nodes, func(a, b *NodeSession) int {
+		return strings.Compare(a.NodeInfo.Id, b.NodeInfo.Id)
+	})
+
+	return nodes
 }
 
 func NewUISession(sessionId string, store CacheService) (*UISession, error) {
@@ -35,5 +84,5 @@ func NewUISession(sessionId string, store CacheService) (*UISession, error) {
 		return nil, err
 	}
 
-	return &UISession{Store: store, Nodes: map[string]*NodeSession{}, SessionPin: pin}, nil
+	return &UISession{Store: store, Nodes: map[string]*NodeSession{}, SessionPin: pin, lastSeen: time.Now()}, nil
 }
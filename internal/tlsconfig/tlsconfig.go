@@ -0,0 +1,211 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// reloadInterval is the least time between checks of the certificate files for rotation
+const reloadInterval = 10 * time.Second
+
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// SelfSigned generates a certificate for Hosts in memory when no files are given, for local use
+	SelfSigned bool
+	Hosts      []string
+	// ClientCAFile holds the CAs client certificates are verified against
+	ClientCAFile string
+	// RequireClientCert rejects connections without a verified client certificate,
+	// otherwise one is only verified when it is presented
+	RequireClientCert bool
+}
+
+// New returns the TLS configuration for the options, or nil when TLS is not configured
+func New(options Options) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch {
+	case options.CertFile != "" || options.KeyFile != "":
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, errors.New("both a certificate and a key file are required")
+		}
+
+		reloader, err := newCertReloader(options.CertFile, options.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.GetCertificate = reloader.getCertificate
+	case options.SelfSigned:
+		cert, err := selfSigned(options.Hosts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	default:
+		if options.ClientCAFile != "" {
+			return nil, errors.New("client certificates require TLS to be configured")
+		}
+
+		return nil, nil
+	}
+
+	if options.ClientCAFile != "" {
+		pem, err := os.ReadFile(options.ClientCAFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file: %w", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", options.ClientCAFile)
+		}
+
+		if options.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return config, nil
+}
+
+// certReloader serves the certificate in the given files, loading it again
+// when the files change so certificates can be rotated without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if time.Since(r.lastCheck) >= reloadInterval {
+		// keep serving the current certificate if the new one can't be loaded, e.g. mid-rotation
+		if err := r.reload(); err != nil {
+			log.Printf("Error reloading TLS certificate: %v\n", err)
+		}
+	}
+
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	r.lastCheck = time.Now()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// selfSigned generates a certificate for the hosts, which are names or IP addresses
+func selfSigned(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Erigon Diagnostics"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range slices.Concat(hosts, []string{"localhost"}) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating certificate: %w", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}